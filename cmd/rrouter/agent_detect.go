@@ -1,7 +1,6 @@
 package main
 
 import (
-	"log"
 	"regexp"
 	"strings"
 )
@@ -26,12 +25,18 @@ func (a AgentType) String() string {
 	}
 }
 
-// AgentRoutingConfig holds agent-type-based routing configuration
+// AgentRoutingConfig holds agent-type-based routing configuration.
+// Groups is the ordered list of agent groups; the group1/group2 fields are
+// the legacy two-group form, used when Groups is empty (see groups).
+// Detectors find the agent name; by default only oh-my-claudecode prompts
+// are recognised.
 type AgentRoutingConfig struct {
-	Enabled      bool     `json:"enabled"`
-	Group1Model  string   `json:"group1Model"`
-	Group1Agents []string `json:"group1Agents"`
-	Group2Agents []string `json:"group2Agents"`
+	Enabled      bool            `json:"enabled"`
+	Detectors    []AgentDetector `json:"detectors,omitempty"`
+	Groups       []AgentGroup    `json:"groups,omitempty"`
+	Group1Model  string          `json:"group1Model,omitempty"`
+	Group1Agents []string        `json:"group1Agents,omitempty"`
+	Group2Agents []string        `json:"group2Agents,omitempty"`
 }
 
 // Package-level compiled regexes for performance (compiled once at startup)
@@ -46,33 +51,7 @@ var (
 // The system prompt can be a string or an array of content blocks.
 // Returns empty string if no agent name found.
 func detectAgentName(data map[string]interface{}) string {
-	systemVal, ok := data["system"]
-	if !ok {
-		return ""
-	}
-
-	var systemText string
-
-	switch v := systemVal.(type) {
-	case string:
-		systemText = v
-	case []interface{}:
-		// system can be array of content blocks: [{"type":"text","text":"..."}]
-		var parts []string
-		for _, item := range v {
-			if block, ok := item.(map[string]interface{}); ok {
-				if text, ok := block["text"].(string); ok {
-					parts = append(parts, text)
-				}
-			}
-		}
-		systemText = strings.Join(parts, " ")
-	case nil:
-		// JSON null
-		return ""
-	default:
-		return ""
-	}
+	systemText := systemPromptText(data)
 
 	if systemText == "" {
 		return ""
@@ -99,46 +78,22 @@ func normalizeAgentName(name string) string {
 }
 
 // classifyAgent determines if an agent is group1 or group2 based on config
+// (the legacy group names; see matchAgentGroup for the general form)
 func classifyAgent(agentName string, routingConfig *AgentRoutingConfig) AgentType {
-	if routingConfig == nil || !routingConfig.Enabled || agentName == "" {
+	if routingConfig == nil || !routingConfig.Enabled {
 		return AgentTypeUnknown
 	}
 
-	for _, agent := range routingConfig.Group1Agents {
-		if strings.EqualFold(agent, agentName) {
-			return AgentTypeGroup1
-		}
+	g := matchAgentGroup(agentName, routingConfig)
+	if g == nil {
+		// Agent name not detected or not in any list -> unknown (safe fallback)
+		return AgentTypeUnknown
 	}
-
-	for _, agent := range routingConfig.Group2Agents {
-		if strings.EqualFold(agent, agentName) {
-			return AgentTypeGroup2
-		}
+	switch g.Name {
+	case "group1":
+		return AgentTypeGroup1
+	case "group2":
+		return AgentTypeGroup2
 	}
-
-	// Agent name detected but not in either list -> unknown (safe fallback)
 	return AgentTypeUnknown
 }
-
-// validateAgentRoutingConfig checks the config for common errors
-func validateAgentRoutingConfig(cfg *AgentRoutingConfig, modeName string) {
-	if cfg == nil || !cfg.Enabled {
-		return
-	}
-
-	// Warn if enabled but group1Model is empty
-	if cfg.Group1Model == "" {
-		log.Printf("[WARN] Mode '%s' has agentRouting.enabled=true but group1Model is empty. Group1 agents will use empty model string.", modeName)
-	}
-
-	// Check for duplicates between lists
-	group1Set := make(map[string]bool)
-	for _, a := range cfg.Group1Agents {
-		group1Set[strings.ToLower(a)] = true
-	}
-	for _, a := range cfg.Group2Agents {
-		if group1Set[strings.ToLower(a)] {
-			log.Printf("[WARN] Mode '%s': Agent '%s' is in both group1Agents and group2Agents. group1 will take precedence.", modeName, a)
-		}
-	}
-}
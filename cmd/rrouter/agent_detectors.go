@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Agent detector types, tried in the configured order.
+const (
+	agentDetectorOMC              = "oh-my-claudecode"   // "oh-my-claudecode:{name}" in the system prompt
+	agentDetectorSystemRegex      = "system_regex"       // Pattern over the system prompt
+	agentDetectorFirstUserMessage = "first_user_message" // Pattern over the first user message
+	agentDetectorMetadata         = "metadata"           // metadata.<Field>
+	agentDetectorHeader           = "header"             // value of the Header request header
+	agentDetectorClaudeCodeTask   = "claude_code_task"   // Claude Code's built-in subagents
+)
+
+const (
+	defaultAgentHeader        = "X-RRouter-Agent"
+	defaultAgentMetadataField = "agent"
+)
+
+// AgentDetector extracts an agent name from a request. Pattern is a regex
+// whose first capture group (or whole match) is the name; it is required for
+// system_regex and first_user_message and optional for metadata and header,
+// where it filters the value.
+type AgentDetector struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern,omitempty"`
+	Field   string `json:"field,omitempty"`  // metadata: dotted path, default "agent"
+	Header  string `json:"header,omitempty"` // header: default X-RRouter-Agent
+}
+
+var defaultAgentDetectors = []AgentDetector{{Type: agentDetectorOMC}}
+
+// claudeCodeTaskAgents maps the opening of each built-in Task subagent
+// prompt to its agent name. Custom subagents need a system_regex detector.
+var claudeCodeTaskAgents = []struct {
+	marker, name string
+}{
+	{"You are a file search specialist for Claude Code", "explore"},
+	{"You are a software architect and planning specialist for Claude Code", "plan"},
+	{"You are a status line setup agent for Claude Code", "statusline-setup"},
+	{"You are an agent for Claude Code, Anthropic's official CLI for Claude", "general-purpose"},
+}
+
+// detectorRegexes caches compiled detector patterns (nil for invalid ones).
+var detectorRegexes sync.Map
+
+// detectAgent runs the configured detectors in order and returns the first
+// normalized agent name found, or "". header may be nil.
+func detectAgent(cfg *AgentRoutingConfig, data map[string]interface{}, header http.Header) string {
+	detectors := defaultAgentDetectors
+	if cfg != nil && len(cfg.Detectors) > 0 {
+		detectors = cfg.Detectors
+	}
+	for _, d := range detectors {
+		if name := d.detect(data, header); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (d AgentDetector) detect(data map[string]interface{}, header http.Header) string {
+	switch d.Type {
+	case agentDetectorOMC:
+		return detectAgentName(data)
+	case agentDetectorSystemRegex:
+		return d.extract(systemPromptText(data))
+	case agentDetectorFirstUserMessage:
+		return d.extract(firstUserText(data))
+	case agentDetectorMetadata:
+		field := d.Field
+		if field == "" {
+			field = defaultAgentMetadataField
+		}
+		var v interface{} = data["metadata"]
+		for _, key := range strings.Split(field, ".") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return ""
+			}
+			v = m[key]
+		}
+		s, _ := v.(string)
+		return d.extractValue(s)
+	case agentDetectorHeader:
+		name := d.Header
+		if name == "" {
+			name = defaultAgentHeader
+		}
+		return d.extractValue(header.Get(name))
+	case agentDetectorClaudeCodeTask:
+		text := systemPromptText(data)
+		for _, a := range claudeCodeTaskAgents {
+			if strings.Contains(text, a.marker) {
+				return a.name
+			}
+		}
+	}
+	return ""
+}
+
+// extract applies Pattern to text; without a pattern nothing matches.
+func (d AgentDetector) extract(text string) string {
+	if text == "" || d.Pattern == "" {
+		return ""
+	}
+	re := compileDetectorRegex(d.Pattern)
+	if re == nil {
+		return ""
+	}
+	m := re.FindStringSubmatch(text)
+	switch {
+	case len(m) > 1:
+		return normalizeAgentName(strings.TrimSpace(m[1]))
+	case len(m) == 1:
+		return normalizeAgentName(strings.TrimSpace(m[0]))
+	}
+	return ""
+}
+
+// extractValue returns a header or metadata value as the agent name,
+// through Pattern if one is set.
+func (d AgentDetector) extractValue(value string) string {
+	if d.Pattern != "" {
+		return d.extract(value)
+	}
+	return normalizeAgentName(strings.TrimSpace(value))
+}
+
+func compileDetectorRegex(expr string) *regexp.Regexp {
+	if v, ok := detectorRegexes.Load(expr); ok {
+		return v.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		re = nil
+	}
+	detectorRegexes.Store(expr, re)
+	return re
+}
+
+// systemPromptText returns the system prompt, joining content blocks.
+func systemPromptText(data map[string]interface{}) string {
+	switch v := data["system"].(type) {
+	case string:
+		return v
+	case []interface{}:
+		// system can be array of content blocks: [{"type":"text","text":"..."}]
+		var parts []string
+		for _, item := range v {
+			if block, ok := item.(map[string]interface{}); ok {
+				if text, ok := block["text"].(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	return ""
+}
+
+// firstUserText returns the text of the first user message, joining its
+// text blocks.
+func firstUserText(data map[string]interface{}) string {
+	messages, _ := data["messages"].([]interface{})
+	for _, msg := range messages {
+		msgMap, ok := msg.(map[string]interface{})
+		if !ok || msgMap["role"] != "user" {
+			continue
+		}
+		switch content := msgMap["content"].(type) {
+		case string:
+			return content
+		case []interface{}:
+			var parts []string
+			for _, item := range content {
+				if block, ok := item.(map[string]interface{}); ok && block["type"] == "text" {
+					if text, ok := block["text"].(string); ok {
+						parts = append(parts, text)
+					}
+				}
+			}
+			return strings.Join(parts, " ")
+		}
+		return ""
+	}
+	return ""
+}
+
+// validateAgentDetectors warns about unknown detector types and missing or
+// invalid patterns.
+func validateAgentDetectors(detectors []AgentDetector, modeName string) {
+	for i, d := range detectors {
+		switch d.Type {
+		case agentDetectorSystemRegex, agentDetectorFirstUserMessage:
+			if d.Pattern == "" {
+				log.Printf("[WARN] Mode '%s': agent detector #%d (%s) has no pattern and will never match", modeName, i+1, d.Type)
+				continue
+			}
+		case agentDetectorOMC, agentDetectorMetadata, agentDetectorHeader, agentDetectorClaudeCodeTask:
+		default:
+			log.Printf("[WARN] Mode '%s': agent detector #%d has unknown type '%s' (valid: %s, %s, %s, %s, %s, %s)", modeName, i+1, d.Type,
+				agentDetectorOMC, agentDetectorSystemRegex, agentDetectorFirstUserMessage, agentDetectorMetadata, agentDetectorHeader, agentDetectorClaudeCodeTask)
+			continue
+		}
+		if d.Pattern != "" {
+			if _, err := regexp.Compile(d.Pattern); err != nil {
+				log.Printf("[WARN] Mode '%s': agent detector #%d (%s) has invalid pattern: %v", modeName, i+1, d.Type, err)
+			}
+		}
+	}
+}
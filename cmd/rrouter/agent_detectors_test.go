@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDetectAgent(t *testing.T) {
+	omcSystem := map[string]interface{}{"system": "Agent oh-my-claudecode:explore started"}
+
+	tests := []struct {
+		name      string
+		detectors []AgentDetector
+		data      map[string]interface{}
+		header    http.Header
+		want      string
+	}{
+		{
+			name: "default is oh-my-claudecode",
+			data: omcSystem,
+			want: "explore",
+		},
+		{
+			name:      "configured detectors replace the default",
+			detectors: []AgentDetector{{Type: agentDetectorHeader}},
+			data:      omcSystem,
+		},
+		{
+			name:      "system regex with capture group",
+			detectors: []AgentDetector{{Type: agentDetectorSystemRegex, Pattern: `\[agent: ([\w-]+)\]`}},
+			data:      map[string]interface{}{"system": []interface{}{map[string]interface{}{"type": "text", "text": "Base prompt [agent: Reviewer]"}}},
+			want:      "reviewer",
+		},
+		{
+			name:      "first user message marker",
+			detectors: []AgentDetector{{Type: agentDetectorFirstUserMessage, Pattern: `@agent=(\S+)`}},
+			data: map[string]interface{}{"messages": []interface{}{
+				map[string]interface{}{"role": "assistant", "content": "@agent=ignored"},
+				map[string]interface{}{"role": "user", "content": []interface{}{
+					map[string]interface{}{"type": "text", "text": "@agent=planner. Plan the work"},
+				}},
+			}},
+			want: "planner",
+		},
+		{
+			name:      "metadata default field",
+			detectors: []AgentDetector{{Type: agentDetectorMetadata}},
+			data:      map[string]interface{}{"metadata": map[string]interface{}{"agent": " Writer "}},
+			want:      "writer",
+		},
+		{
+			name:      "metadata dotted field with pattern",
+			detectors: []AgentDetector{{Type: agentDetectorMetadata, Field: "tags.role", Pattern: `^role:(.+)$`}},
+			data:      map[string]interface{}{"metadata": map[string]interface{}{"tags": map[string]interface{}{"role": "role:critic"}}},
+			want:      "critic",
+		},
+		{
+			name:      "default header",
+			detectors: []AgentDetector{{Type: agentDetectorHeader}},
+			header:    http.Header{"X-Rrouter-Agent": {"Executor-High"}},
+			want:      "executor-high",
+		},
+		{
+			name:      "custom header",
+			detectors: []AgentDetector{{Type: agentDetectorHeader, Header: "X-Agent-Name"}},
+			header:    http.Header{"X-Agent-Name": {"scientist"}},
+			want:      "scientist",
+		},
+		{
+			name:      "claude code explore subagent",
+			detectors: []AgentDetector{{Type: agentDetectorClaudeCodeTask}},
+			data: map[string]interface{}{"system": []interface{}{
+				map[string]interface{}{"type": "text", "text": "You are Claude Code, Anthropic's official CLI for Claude."},
+				map[string]interface{}{"type": "text", "text": "You are a file search specialist for Claude Code, Anthropic's official CLI for Claude."},
+			}},
+			want: "explore",
+		},
+		{
+			name:      "claude code main session is not an agent",
+			detectors: []AgentDetector{{Type: agentDetectorClaudeCodeTask}},
+			data:      map[string]interface{}{"system": "You are Claude Code, Anthropic's official CLI for Claude."},
+		},
+		{
+			name:      "first detector with a result wins",
+			detectors: []AgentDetector{{Type: agentDetectorHeader}, {Type: agentDetectorOMC}},
+			data:      omcSystem,
+			header:    http.Header{"X-Rrouter-Agent": {"writer"}},
+			want:      "writer",
+		},
+		{
+			name:      "falls through to later detectors",
+			detectors: []AgentDetector{{Type: agentDetectorHeader}, {Type: agentDetectorOMC}},
+			data:      omcSystem,
+			want:      "explore",
+		},
+		{
+			name:      "invalid pattern never matches",
+			detectors: []AgentDetector{{Type: agentDetectorSystemRegex, Pattern: `(`}},
+			data:      omcSystem,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &AgentRoutingConfig{Enabled: true, Detectors: tt.detectors}
+			if got := detectAgent(cfg, tt.data, tt.header); got != tt.want {
+				t.Errorf("detectAgent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteRequest_HeaderDetector(t *testing.T) {
+	mc := &ModeConfig{
+		Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-flash"}},
+		AgentRouting: &AgentRoutingConfig{
+			Enabled:   true,
+			Detectors: []AgentDetector{{Type: agentDetectorHeader}},
+			Groups:    []AgentGroup{{Name: "planners", Agents: []string{"planner"}, Model: "gemini-pro"}},
+		},
+	}
+	body := []byte(`{"model":"claude-sonnet-4","messages":[]}`)
+
+	res, err := rewriteRequest(body, mc, "gemini", "", http.Header{"X-Rrouter-Agent": {"planner"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.model != "gemini-pro" {
+		t.Errorf("model = %q, want gemini-pro", res.model)
+	}
+
+	res, err = rewriteRequest(body, mc, "gemini", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.model != "gemini-flash" {
+		t.Errorf("without header, model = %q, want gemini-flash", res.model)
+	}
+}
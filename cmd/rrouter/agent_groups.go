@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// AgentGroup routes the agents it lists. At most one target is used, in
+// this order: Mode sends the request through another mode (its upstream and
+// mappings), Model rewrites every request to one model, Mappings rewrites by
+// client model. A group without a target keeps the standard mapping.
+type AgentGroup struct {
+	Name     string         `json:"name"`
+	Agents   []string       `json:"agents"`
+	Mode     string         `json:"mode,omitempty"`
+	Model    string         `json:"model,omitempty"`
+	Mappings []ModelMapping `json:"mappings,omitempty"`
+}
+
+// groups returns the configured groups, converting the legacy form: group1
+// agents get group1Model, group2 agents keep the standard mapping.
+func (cfg *AgentRoutingConfig) groups() []AgentGroup {
+	if len(cfg.Groups) > 0 {
+		return cfg.Groups
+	}
+	return []AgentGroup{
+		{Name: "group1", Agents: cfg.Group1Agents, Model: cfg.Group1Model},
+		{Name: "group2", Agents: cfg.Group2Agents},
+	}
+}
+
+// matchAgentGroup returns the group for agentName, or nil. Agent entries are
+// exact names (case-insensitive), globs ("explore*", "*-low") or regexes
+// ("re:^(architect|critic)"). An exact name wins over any pattern; among
+// patterns the first group (and first entry) in order wins.
+func matchAgentGroup(agentName string, cfg *AgentRoutingConfig) *AgentGroup {
+	if cfg == nil || !cfg.Enabled || agentName == "" {
+		return nil
+	}
+	groups := cfg.groups()
+	for i := range groups {
+		for _, agent := range groups[i].Agents {
+			if !isAgentPattern(agent) && strings.EqualFold(agent, agentName) {
+				return &groups[i]
+			}
+		}
+	}
+	for i := range groups {
+		for _, agent := range groups[i].Agents {
+			if isAgentPattern(agent) && matchAgentPattern(agent, agentName) {
+				return &groups[i]
+			}
+		}
+	}
+	return nil
+}
+
+const agentRegexPrefix = "re:"
+
+// agentRegexes caches compiled "re:" patterns (nil for invalid ones).
+var agentRegexes sync.Map
+
+func isAgentPattern(agent string) bool {
+	return strings.HasPrefix(agent, agentRegexPrefix) || strings.ContainsAny(agent, "*?[")
+}
+
+// matchAgentPattern matches a glob or "re:" pattern against the whole agent
+// name, case-insensitively.
+func matchAgentPattern(pattern, agentName string) bool {
+	if expr, ok := strings.CutPrefix(pattern, agentRegexPrefix); ok {
+		re := compileAgentRegex(expr)
+		return re != nil && re.MatchString(agentName)
+	}
+	matched, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(agentName))
+	return err == nil && matched
+}
+
+func compileAgentRegex(expr string) *regexp.Regexp {
+	if v, ok := agentRegexes.Load(expr); ok {
+		return v.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile("(?i)^(?:" + expr + ")$")
+	if err != nil {
+		re = nil
+	}
+	agentRegexes.Store(expr, re)
+	return re
+}
+
+// rewrite returns the model for a request from this group, given the
+// client's model and the model chosen so far, plus the group mapping used
+// (for its transform), if any.
+func (g *AgentGroup) rewrite(originalModel, current, sessionKey string) (string, *ModelMapping) {
+	if g.Mode != "" {
+		// Rerouted to g.Mode before rewriting (see agentModeFor)
+		return current, nil
+	}
+	if g.Model != "" {
+		return g.Model, nil
+	}
+	for i := range g.Mappings {
+		m := &g.Mappings[i]
+		if !matchModel(m.Match, originalModel) {
+			continue
+		}
+		if len(m.Targets) > 0 {
+			return m.pickTarget(originalModel, sessionKey), m
+		}
+		return m.Rewrite, m
+	}
+	return current, nil
+}
+
+// target describes where the group sends requests, for logs.
+func (g *AgentGroup) target() string {
+	switch {
+	case g.Mode != "":
+		return "mode " + g.Mode
+	case g.Model != "":
+		return g.Model
+	case len(g.Mappings) > 0:
+		return "group mappings"
+	}
+	return "standard"
+}
+
+// label names the group in stats.
+func (g *AgentGroup) label() string {
+	if g.Name == "" {
+		return "unnamed"
+	}
+	return g.Name
+}
+
+// agentModeFor returns the detected agent and its group when an agent group
+// of mode reroutes the request to another mode.
+func agentModeFor(bodyBytes []byte, header http.Header, mode string) (agent string, group *AgentGroup) {
+	mc, ok := appConfig.Modes[mode]
+	if !ok || mc.AgentRouting == nil || !mc.AgentRouting.Enabled || len(bodyBytes) == 0 {
+		return "", nil
+	}
+	var data map[string]interface{}
+	if json.Unmarshal(bodyBytes, &data) != nil {
+		return "", nil
+	}
+	agent = detectAgent(mc.AgentRouting, data, header)
+	if g := matchAgentGroup(agent, mc.AgentRouting); g != nil && g.Mode != "" && g.Mode != mode {
+		return agent, g
+	}
+	return "", nil
+}
+
+// validateAgentRoutingConfig checks the config for common errors
+func validateAgentRoutingConfig(cfg *AgentRoutingConfig, modeName string, modes map[string]ModeConfig) {
+	if cfg == nil || !cfg.Enabled {
+		return
+	}
+	validateAgentDetectors(cfg.Detectors, modeName)
+
+	if len(cfg.Groups) > 0 {
+		if cfg.Group1Model != "" || len(cfg.Group1Agents) > 0 || len(cfg.Group2Agents) > 0 {
+			log.Printf("[WARN] Mode '%s': agentRouting.groups is set; group1/group2 fields are ignored", modeName)
+		}
+	} else if cfg.Group1Model == "" {
+		log.Printf("[WARN] Mode '%s' has agentRouting.enabled=true but group1Model is empty. Group1 agents will use the standard mapping.", modeName)
+	}
+
+	// Exact duplicates, invalid patterns, and patterns that overlap
+	// entries of another group
+	var exact, patterns []agentEntry
+	seen := make(map[string]string)
+	for i, g := range cfg.groups() {
+		name := g.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+		targets := 0
+		for _, set := range []bool{g.Mode != "", g.Model != "", len(g.Mappings) > 0} {
+			if set {
+				targets++
+			}
+		}
+		if targets > 1 {
+			log.Printf("[WARN] Mode '%s': agent group '%s' sets more than one of mode/model/mappings; using %s", modeName, name, g.target())
+		}
+		if g.Mode != "" {
+			if _, ok := modes[g.Mode]; !ok {
+				log.Printf("[WARN] Mode '%s': agent group '%s' routes to unknown mode '%s'", modeName, name, g.Mode)
+			}
+		}
+		for _, a := range g.Agents {
+			if isAgentPattern(a) {
+				if err := validateAgentPattern(a); err != nil {
+					log.Printf("[WARN] Mode '%s': agent group '%s' has invalid pattern '%s': %v", modeName, name, a, err)
+					continue
+				}
+				patterns = append(patterns, agentEntry{a, name})
+				continue
+			}
+			key := strings.ToLower(a)
+			if first, ok := seen[key]; ok {
+				log.Printf("[WARN] Mode '%s': Agent '%s' is in groups '%s' and '%s'. '%s' will take precedence.", modeName, a, first, name, first)
+				continue
+			}
+			seen[key] = name
+			exact = append(exact, agentEntry{a, name})
+		}
+	}
+
+	for _, p := range patterns {
+		for _, e := range exact {
+			if e.group != p.group && matchAgentPattern(p.agent, e.agent) {
+				log.Printf("[WARN] Mode '%s': pattern '%s' (group '%s') also matches '%s'; the exact entry in group '%s' takes precedence.", modeName, p.agent, p.group, e.agent, e.group)
+			}
+		}
+	}
+	for i, p := range patterns {
+		for _, q := range patterns[i+1:] {
+			if p.group == q.group {
+				continue
+			}
+			if probe, ok := agentPatternsOverlap(p.agent, q.agent, exact); ok {
+				log.Printf("[WARN] Mode '%s': patterns '%s' (group '%s') and '%s' (group '%s') both match '%s'; '%s' takes precedence.", modeName, p.agent, p.group, q.agent, q.group, probe, p.group)
+			}
+		}
+	}
+}
+
+// agentEntry is one agent name or pattern and the group listing it.
+type agentEntry struct {
+	agent, group string
+}
+
+func validateAgentPattern(pattern string) error {
+	if expr, ok := strings.CutPrefix(pattern, agentRegexPrefix); ok {
+		_, err := regexp.Compile(expr)
+		return err
+	}
+	_, err := filepath.Match(pattern, "")
+	return err
+}
+
+// agentPatternsOverlap looks for a name both patterns match, probing the
+// configured exact names and a sample name derived from each glob
+// ("explore*" -> "explorex"). Regex-only overlaps with no such witness are
+// not detected.
+func agentPatternsOverlap(p, q string, exact []agentEntry) (string, bool) {
+	probes := []string{globSample(p), globSample(q)}
+	for _, e := range exact {
+		probes = append(probes, e.agent)
+	}
+	for _, probe := range probes {
+		if probe != "" && matchAgentPattern(p, probe) && matchAgentPattern(q, probe) {
+			return probe, true
+		}
+	}
+	return "", false
+}
+
+// globSample turns a glob into a name it matches, or "" for regexes and
+// character classes.
+func globSample(pattern string) string {
+	if strings.HasPrefix(pattern, agentRegexPrefix) || strings.Contains(pattern, "[") {
+		return ""
+	}
+	return strings.NewReplacer("*", "x", "?", "x").Replace(pattern)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func agentRequest(agent, model string) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    model,
+		"system":   "Agent oh-my-claudecode:" + agent + " started",
+		"messages": []interface{}{},
+	})
+	return body
+}
+
+func TestAgentGroups_Rewrite(t *testing.T) {
+	mc := &ModeConfig{
+		Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-pro"}},
+		AgentRouting: &AgentRoutingConfig{
+			Enabled: true,
+			Groups: []AgentGroup{
+				{Name: "planners", Agents: []string{"planner"}, Model: "claude-opus-4"},
+				{Name: "explorers", Agents: []string{"explore", "researcher"}, Mappings: []ModelMapping{
+					{Match: "claude-haiku-*", Rewrite: "gemini-flash-lite"},
+					{Match: "claude-*", Rewrite: "gemini-flash"},
+				}},
+				{Name: "reviewers", Agents: []string{"code-reviewer"}, Mode: "claude"},
+				{Name: "writers", Agents: []string{"writer", "planner"}, Model: "unused"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		agent     string
+		model     string
+		wantModel string
+	}{
+		{"model target", "planner", "claude-sonnet-4", "claude-opus-4"},
+		{"mapping target", "explore", "claude-sonnet-4", "gemini-flash"},
+		{"mapping target by client model", "researcher", "claude-haiku-4", "gemini-flash-lite"},
+		{"mode target leaves model to that mode", "code-reviewer", "claude-sonnet-4", "gemini-pro"},
+		{"first group wins", "planner", "claude-haiku-4", "claude-opus-4"},
+		{"unknown agent uses standard mapping", "executor", "claude-sonnet-4", "gemini-pro"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := rewriteRequest(agentRequest(tt.agent, tt.model), mc, "gemini", "", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.model != tt.wantModel {
+				t.Errorf("model = %q, want %q", res.model, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestAgentGroups_LegacyConversion(t *testing.T) {
+	cfg := &AgentRoutingConfig{
+		Enabled:      true,
+		Group1Model:  "gemini-3-pro-preview",
+		Group1Agents: []string{"explore"},
+		Group2Agents: []string{"executor"},
+	}
+	mc := &ModeConfig{
+		Mappings:     []ModelMapping{{Match: "claude-*", Rewrite: "gemini-flash"}},
+		AgentRouting: cfg,
+	}
+
+	for agent, want := range map[string]string{"explore": "gemini-3-pro-preview", "executor": "gemini-flash"} {
+		res, err := rewriteRequest(agentRequest(agent, "claude-sonnet-4"), mc, "antigravity", "", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if res.model != want {
+			t.Errorf("%s: model = %q, want %q", agent, res.model, want)
+		}
+	}
+}
+
+func TestAgentModeFor(t *testing.T) {
+	withAppConfig(t, &Config{Modes: map[string]ModeConfig{
+		"gemini": {AgentRouting: &AgentRoutingConfig{
+			Enabled: true,
+			Groups: []AgentGroup{
+				{Name: "reviewers", Agents: []string{"code-reviewer"}, Mode: "claude"},
+				{Name: "self", Agents: []string{"explore"}, Mode: "gemini"},
+			},
+		}},
+		"claude": {},
+	}})
+
+	tests := []struct {
+		agent    string
+		mode     string
+		wantMode string
+	}{
+		{"code-reviewer", "gemini", "claude"},
+		{"explore", "gemini", ""}, // already there
+		{"executor", "gemini", ""},
+		{"code-reviewer", "claude", ""}, // no agent routing in claude
+	}
+	for _, tt := range tests {
+		_, g := agentModeFor(agentRequest(tt.agent, "claude-sonnet-4"), nil, tt.mode)
+		got := ""
+		if g != nil {
+			got = g.Mode
+		}
+		if got != tt.wantMode {
+			t.Errorf("agentModeFor(%s in %s) = %q, want %q", tt.agent, tt.mode, got, tt.wantMode)
+		}
+	}
+}
+
+func TestMatchAgentGroup_Patterns(t *testing.T) {
+	cfg := &AgentRoutingConfig{
+		Enabled: true,
+		Groups: []AgentGroup{
+			{Name: "cheap", Agents: []string{"*-low"}},
+			{Name: "explorers", Agents: []string{"explore*", "re:(architect|critic)(-.*)?"}},
+			{Name: "executors", Agents: []string{"executor*", "explore-high"}},
+		},
+	}
+
+	tests := []struct {
+		agent string
+		want  string
+	}{
+		{"explore", "explorers"},
+		{"explore-medium", "explorers"},
+		{"EXPLORE-MEDIUM", "explorers"},
+		{"explore-high", "executors"}, // exact name beats an earlier pattern
+		{"explore-low", "cheap"},      // first pattern in group order
+		{"architect-medium", "explorers"},
+		{"critic", "explorers"},
+		{"my-critic", ""}, // regexes match the whole name
+		{"executor-low", "cheap"},
+		{"executor", "executors"},
+		{"writer", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if g := matchAgentGroup(tt.agent, cfg); g != nil {
+			got = g.Name
+		}
+		if got != tt.want {
+			t.Errorf("matchAgentGroup(%q) = %q, want %q", tt.agent, got, tt.want)
+		}
+	}
+}
+
+func TestAgentPatternsOverlap(t *testing.T) {
+	exact := []agentEntry{{"architect-low", "a"}}
+	tests := []struct {
+		p, q      string
+		wantProbe string
+	}{
+		{"explore*", "explore-*", "explore-x"},
+		{"*-low", "re:architect.*", "architect-low"},
+		{"explore*", "executor*", ""},
+		{"re:foo", "re:bar", ""},
+	}
+	for _, tt := range tests {
+		probe, ok := agentPatternsOverlap(tt.p, tt.q, exact)
+		if probe != tt.wantProbe || ok != (tt.wantProbe != "") {
+			t.Errorf("agentPatternsOverlap(%q, %q) = %q, %v; want %q", tt.p, tt.q, probe, ok, tt.wantProbe)
+		}
+	}
+}
+
+func TestProxyHandler_AgentModeAutoFailover(t *testing.T) {
+	tests := []struct {
+		groupMode   string
+		failModel   string // model the failing group mode sends
+		wantRetried string // model seen on the retry
+	}{
+		{"gemini", "gemini-pro", "claude-sonnet-4"}, // retry on claude, not back to antigravity
+		{"claude", "claude-sonnet-4", "ag-sonnet"},  // moved to the other target: retry on the auto target
+	}
+	for _, tt := range tests {
+		var models []string
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Model string `json:"model"`
+			}
+			b, _ := io.ReadAll(r.Body)
+			json.Unmarshal(b, &req)
+			models = append(models, req.Model)
+			w.Header().Set("Content-Type", "application/json")
+			if req.Model == tt.failModel && len(models) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			w.Write([]byte(`{"model":"` + req.Model + `"}`))
+		}))
+
+		cfg := &Config{
+			DefaultMode: "antigravity",
+			Modes: map[string]ModeConfig{
+				"antigravity": {
+					Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "ag-sonnet"}},
+					AgentRouting: &AgentRoutingConfig{
+						Enabled: true,
+						Groups:  []AgentGroup{{Name: "moved", Agents: []string{"code-reviewer"}, Mode: tt.groupMode}},
+					},
+				},
+				"claude": {},
+				"gemini": {Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-pro"}}},
+			},
+		}
+		withProxyGlobals(t, cfg)
+		if err := os.WriteFile(filepath.Join(configWatcher.dir, "mode"), []byte("auto"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		upstreams, err := newUpstreamSet(cfg, backend.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(agentRequest("code-reviewer", "claude-sonnet-4")))
+		proxyHandler(upstreams)(rec, req)
+		backend.Close()
+
+		if rec.Code != http.StatusOK || len(models) != 2 || models[1] != tt.wantRetried {
+			t.Errorf("group mode %s: status %d, upstream models %v, want retry with %s", tt.groupMode, rec.Code, models, tt.wantRetried)
+		}
+		autoSwitch.mu.Lock()
+		current, failures := autoSwitch.currentTarget, autoSwitch.failureCount
+		autoSwitch.mu.Unlock()
+		if current != "antigravity" || (tt.groupMode == "gemini" && failures != 0) {
+			t.Errorf("group mode %s: auto state target=%s failures=%d, want antigravity with the group's failure not counted", tt.groupMode, current, failures)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// agentGroupNone is the group label of detected agents no group lists.
+const agentGroupNone = "none"
+
+// Agent names come from the client (headers, metadata), so only the first
+// maxAgentLabels distinct names get their own stats rows; later ones are
+// counted as agentOther.
+const (
+	maxAgentLabels = 100
+	agentOther     = "other"
+)
+
+// agentRoute records the agent and group that moved a request to another
+// mode, so its attempts are counted even if that mode doesn't detect agents.
+type agentRoute struct {
+	agent, group string
+}
+
+var (
+	agentStats      = newStatsTable("rrouter_agent", "agent", "group")
+	agentGroupStats = newStatsTable("rrouter_agent_group", "group", "mode")
+
+	agentLabelsMu sync.Mutex
+	agentLabels   = make(map[string]bool)
+)
+
+// observeAgent records an attempt made for a detected agent.
+func observeAgent(out attemptOutcome, info *routeInfo) {
+	agentStats.observe(out, agentLabel(info.agent), info.agentGroup)
+	agentGroupStats.observe(out, info.agentGroup, info.mode)
+}
+
+// agentLabel returns the stats label of an agent name: the name itself
+// while fewer than maxAgentLabels names are tracked, else agentOther.
+func agentLabel(name string) string {
+	agentLabelsMu.Lock()
+	defer agentLabelsMu.Unlock()
+	if !agentLabels[name] {
+		if len(agentLabels) >= maxAgentLabels {
+			return agentOther
+		}
+		agentLabels[name] = true
+	}
+	return name
+}
+
+// agentHealthInfo returns per-agent and per-group (and mode) counters, or
+// nil before the first agent request.
+func agentHealthInfo() map[string]interface{} {
+	byAgent := agentStats.HealthInfo()
+	if len(byAgent) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"byAgent": byAgent,
+		"byGroup": agentGroupStats.HealthInfo(),
+	}
+}
+
+// agentStatusRow is one row of `rrouter status --agents`.
+type agentStatusRow struct {
+	name         string
+	requests     float64
+	errorRate    float64
+	avgLatencyMs float64
+	inputTokens  float64
+	outputTokens float64
+}
+
+// agentStatusRows converts a /health stats map into rows, busiest first.
+func agentStatusRows(stats map[string]interface{}) []agentStatusRow {
+	var rows []agentStatusRow
+	for name, v := range stats {
+		m, _ := v.(map[string]interface{})
+		num := func(key string) float64 {
+			f, _ := m[key].(float64)
+			return f
+		}
+		rows = append(rows, agentStatusRow{
+			name:         name,
+			requests:     num("requests"),
+			errorRate:    num("errorRate"),
+			avgLatencyMs: num("avgLatencyMs"),
+			inputTokens:  num("inputTokens"),
+			outputTokens: num("outputTokens"),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].requests != rows[j].requests {
+			return rows[i].requests > rows[j].requests
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
+// writeAgentTable prints rows under a title, with each row's share of all
+// requests in the table.
+func writeAgentTable(w io.Writer, title, column string, rows []agentStatusRow) {
+	var total float64
+	for _, r := range rows {
+		total += r.requests
+	}
+	fmt.Fprintf(w, "  %s:\n", title)
+	fmt.Fprintf(w, "    %-32s %8s %6s %6s %10s %12s %12s\n", column, "REQS", "SHARE", "ERR%", "AVG", "IN TOKENS", "OUT TOKENS")
+	for _, r := range rows {
+		fmt.Fprintf(w, "    %-32s %8.0f %5.1f%% %5.1f%% %10s %12.0f %12.0f\n",
+			r.name, r.requests, 100*r.requests/total, 100*r.errorRate,
+			formatDuration(time.Duration(r.avgLatencyMs)*time.Millisecond), r.inputTokens, r.outputTokens)
+	}
+}
+
+// showAgentStats prints the daemon's per-agent and per-group statistics.
+func showAgentStats() {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(healthURL())
+	if err != nil {
+		fmt.Printf("  Agent stats unavailable: %v\n", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Agents struct {
+			ByAgent map[string]interface{} `json:"byAgent"`
+			ByGroup map[string]interface{} `json:"byGroup"`
+		} `json:"agents"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		fmt.Printf("  Agent stats unavailable: %v\n", err)
+		return
+	}
+	if len(health.Agents.ByAgent) == 0 {
+		fmt.Println("  No agent requests seen yet (agent routing off or no agents detected)")
+		return
+	}
+
+	fmt.Println("Agents (since daemon start):")
+	fmt.Println()
+	writeAgentTable(os.Stdout, "By group", "GROUP/MODE", agentStatusRows(health.Agents.ByGroup))
+	fmt.Println()
+	writeAgentTable(os.Stdout, "By agent", "AGENT/GROUP", agentStatusRows(health.Agents.ByAgent))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAgentStats(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Model == "gemini-pro" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, `{"model":"`+req.Model+`","usage":{"input_tokens":10,"output_tokens":5}}`)
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		DefaultMode: "gemini",
+		Modes: map[string]ModeConfig{
+			"gemini": {
+				Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-flash"}},
+				AgentRouting: &AgentRoutingConfig{
+					Enabled: true,
+					Groups: []AgentGroup{
+						{Name: "stats-explorers", Agents: []string{"stats-explore*"}, Model: "gemini-pro"},
+						{Name: "stats-reviewers", Agents: []string{"stats-reviewer"}, Mode: "claude"},
+					},
+				},
+			},
+			"claude": {},
+		},
+	}
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := proxyHandler(upstreams)
+
+	for _, agent := range []string{"stats-explore", "stats-explore", "stats-reviewer", "stats-writer"} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(agentRequest(agent, "claude-sonnet-4")))
+		handler(httptest.NewRecorder(), req)
+	}
+
+	byAgent := agentStats.HealthInfo()
+	tests := []struct {
+		key          string
+		wantRequests uint64
+		wantErrors   uint64
+	}{
+		{"stats-explore/stats-explorers", 2, 2},
+		{"stats-reviewer/stats-reviewers", 1, 0}, // counted in the mode it moved to
+		{"stats-writer/none", 1, 0},
+	}
+	for _, tt := range tests {
+		row, ok := byAgent[tt.key].(map[string]interface{})
+		if !ok {
+			t.Errorf("missing agent row %q in %v", tt.key, byAgent)
+			continue
+		}
+		if row["requests"] != tt.wantRequests || row["errors"] != tt.wantErrors {
+			t.Errorf("%s: requests=%v errors=%v, want %d/%d", tt.key, row["requests"], row["errors"], tt.wantRequests, tt.wantErrors)
+		}
+		if wantTokens := int64(10 * (tt.wantRequests - tt.wantErrors)); row["inputTokens"] != wantTokens {
+			t.Errorf("%s: inputTokens=%v", tt.key, row["inputTokens"])
+		}
+	}
+
+	byGroup := agentGroupStats.HealthInfo()
+	for _, key := range []string{"stats-explorers/gemini", "stats-reviewers/claude"} {
+		if _, ok := byGroup[key]; !ok {
+			t.Errorf("missing group row %q in %v", key, byGroup)
+		}
+	}
+	if agentHealthInfo() == nil {
+		t.Error("agentHealthInfo() = nil after agent requests")
+	}
+}
+
+func TestAgentLabel_Capped(t *testing.T) {
+	agentLabelsMu.Lock()
+	saved := agentLabels
+	agentLabels = make(map[string]bool)
+	agentLabelsMu.Unlock()
+	t.Cleanup(func() {
+		agentLabelsMu.Lock()
+		agentLabels = saved
+		agentLabelsMu.Unlock()
+	})
+
+	for i := 0; i < maxAgentLabels; i++ {
+		name := fmt.Sprintf("agent-%d", i)
+		if got := agentLabel(name); got != name {
+			t.Fatalf("agentLabel(%s) = %q under the cap", name, got)
+		}
+	}
+	if got := agentLabel("one-too-many"); got != agentOther {
+		t.Errorf("agentLabel over the cap = %q, want %q", got, agentOther)
+	}
+	if got := agentLabel("agent-0"); got != "agent-0" {
+		t.Errorf("known agent over the cap = %q, want agent-0", got)
+	}
+}
+
+func TestAgentStatusRows(t *testing.T) {
+	stats := map[string]interface{}{
+		"writer/none":     map[string]interface{}{"requests": 1.0, "errorRate": 0.0, "avgLatencyMs": 120.0},
+		"explore/group1":  map[string]interface{}{"requests": 3.0, "errorRate": 1.0 / 3, "avgLatencyMs": 2500.0, "inputTokens": 300.0},
+		"executor/group2": map[string]interface{}{"requests": 1.0},
+	}
+	rows := agentStatusRows(stats)
+	var names []string
+	for _, r := range rows {
+		names = append(names, r.name)
+	}
+	if got := strings.Join(names, ","); got != "explore/group1,executor/group2,writer/none" {
+		t.Errorf("row order = %s", got)
+	}
+
+	var buf bytes.Buffer
+	writeAgentTable(&buf, "By agent", "AGENT/GROUP", rows)
+	out := buf.String()
+	for _, want := range []string{"By agent:", "explore/group1", " 60.0%", " 33.3%", "2.5s", "120ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("table missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -144,6 +144,36 @@ func (s *autoState) recordUpstreamResponse(statusCode int, isTimeout bool) {
 	}
 }
 
+// recordTargetResponse is recordUpstreamResponse for a request that was sent
+// to a specific target. With onlyCurrent (pinned sessions, hedge legs),
+// responses from a target other than currentTarget don't drive switching;
+// otherwise every response counts, as for plain auto-mode requests.
+func (s *autoState) recordTargetResponse(target string, onlyCurrent bool, statusCode int, isTimeout bool) {
+	if !onlyCurrent {
+		s.recordUpstreamResponse(statusCode, isTimeout)
+		return
+	}
+	s.mu.Lock()
+	current := s.currentTarget
+	s.mu.Unlock()
+
+	if target != current {
+		if isTimeout || statusCode >= 400 {
+			log.Printf("[AUTO] Failure on non-current target %s (HTTP %d, timeout: %v) -- not counted", target, statusCode, isTimeout)
+		}
+		return
+	}
+	s.recordUpstreamResponse(statusCode, isTimeout)
+}
+
+// isFailing reports whether target is the one auto mode switched away from
+// and is still cooling down.
+func (s *autoState) isFailing(target string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return target != s.currentTarget && target == s.previousTarget
+}
+
 // triggerSwitch flips the current target to the opposite one.
 // MUST be called with s.mu held.
 func (s *autoState) triggerSwitch(reason string) {
@@ -739,3 +739,74 @@ func TestCooldownTimer_SetsHealthySince(t *testing.T) {
 		t.Error("healthySince not set after cooldown expired")
 	}
 }
+
+// ========== 13. Target-aware recording ==========
+
+func TestRecordTargetResponse_NonCurrentTargetNotCounted(t *testing.T) {
+	s := newAutoState("antigravity")
+	s.recordTargetResponse("claude", true, 500, false)
+	s.recordTargetResponse("claude", true, 500, false)
+	s.recordTargetResponse("claude", true, 500, false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.switched {
+		t.Error("failures on a non-current target must not trigger a switch")
+	}
+	if s.failureCount != 0 {
+		t.Errorf("failureCount = %d, want 0", s.failureCount)
+	}
+}
+
+func TestRecordTargetResponse_FallbackSuccessKeepsPrimaryFailures(t *testing.T) {
+	s := newAutoState("antigravity")
+	s.recordTargetResponse("antigravity", true, 500, false)
+	s.recordTargetResponse("claude", true, 200, false) // retry succeeded on fallback
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failureCount != 1 {
+		t.Errorf("failureCount = %d, want 1 (fallback success must not reset primary)", s.failureCount)
+	}
+}
+
+func TestRecordTargetResponse_UnpinnedCountsEveryTarget(t *testing.T) {
+	s := newAutoState("antigravity")
+	s.recordTargetResponse("antigravity", false, 500, false)
+	s.recordTargetResponse("antigravity", false, 500, false)
+	s.recordTargetResponse("claude", false, 200, false) // fallback success resets, as before pinning
+
+	s.mu.Lock()
+	if s.failureCount != 0 {
+		t.Errorf("failureCount = %d, want 0", s.failureCount)
+	}
+	s.mu.Unlock()
+
+	s.recordTargetResponse("claude", false, 500, false)
+	s.recordTargetResponse("claude", false, 500, false)
+	s.recordTargetResponse("claude", false, 500, false)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.switched {
+		t.Error("unpinned failures count regardless of target")
+	}
+}
+
+func TestIsFailing(t *testing.T) {
+	s := newAutoState("antigravity")
+	if s.isFailing("antigravity") || s.isFailing("claude") {
+		t.Fatal("no target should be failing initially")
+	}
+
+	s.recordUpstreamResponse(500, false)
+	s.recordUpstreamResponse(500, false)
+	s.recordUpstreamResponse(500, false)
+
+	if !s.isFailing("antigravity") {
+		t.Error("antigravity should be failing after switching away from it")
+	}
+	if s.isFailing("claude") {
+		t.Error("claude (current target) should not be failing")
+	}
+}
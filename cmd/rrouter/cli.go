@@ -8,6 +8,7 @@ import (
 const Version = "4.0.0"
 
 func main() {
+	os.Args = extractProfileFlag(os.Args)
 	if len(os.Args) < 2 {
 		cmdHelp()
 		os.Exit(0)
@@ -23,7 +24,7 @@ func main() {
 	case "restart":
 		cmdRestart()
 	case "status":
-		cmdStatus()
+		cmdStatus(os.Args[2:])
 	case "antigravity", "ag":
 		cmdAntigravity()
 	case "claude", "c":
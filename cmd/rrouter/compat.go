@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+)
+
+// Backend families. Thinking-block signatures are only valid for the family
+// that issued them.
+const (
+	backendAnthropic = "anthropic"
+	backendGemini    = "gemini"
+)
+
+// Values for ModeConfig.UnsignedThinking.
+const (
+	unsignedThinkingText = "text" // convert to a plain text block (default)
+	unsignedThinkingDrop = "drop"
+)
+
+const signatureRegistrySize = 10000
+
+// backendFor returns the backend family for a mode. Without an explicit
+// "backend" setting, the claude mode is Anthropic and every other mode is
+// assumed to be Gemini-backed.
+func backendFor(mode string, modeConfig *ModeConfig) string {
+	if modeConfig != nil && modeConfig.Backend != "" {
+		return modeConfig.Backend
+	}
+	if mode == "claude" {
+		return backendAnthropic
+	}
+	return backendGemini
+}
+
+// signatureRegistry remembers which backend issued each thinking-block
+// signature seen in upstream responses. It is bounded; the oldest entries
+// are evicted first. Like autoState, it is intentionally not persisted.
+type signatureRegistry struct {
+	mu      sync.Mutex
+	issuer  map[string]string
+	order   []string
+	next    int
+	maxSize int
+}
+
+func newSignatureRegistry(maxSize int) *signatureRegistry {
+	return &signatureRegistry{
+		issuer:  make(map[string]string, maxSize),
+		order:   make([]string, 0, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+var thinkingSignatures = newSignatureRegistry(signatureRegistrySize)
+
+func signatureKey(sig string) string {
+	sum := sha256.Sum256([]byte(sig))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (r *signatureRegistry) record(sig, backend string) {
+	if sig == "" {
+		return
+	}
+	key := signatureKey(sig)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.issuer[key]; ok {
+		r.issuer[key] = backend
+		return
+	}
+	if len(r.order) < r.maxSize {
+		r.order = append(r.order, key)
+	} else {
+		delete(r.issuer, r.order[r.next])
+		r.order[r.next] = key
+		r.next = (r.next + 1) % r.maxSize
+	}
+	r.issuer[key] = backend
+}
+
+// lookup returns the backend that issued sig, or "" if unknown.
+func (r *signatureRegistry) lookup(sig string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.issuer[signatureKey(sig)]
+}
+
+func (r *signatureRegistry) size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.issuer)
+}
+
+// signatureSSEHook records signatures from streamed thinking blocks
+// (content_block_start and signature_delta events).
+func signatureSSEHook(backend string) sseHook {
+	return func(event string, data []byte) []byte {
+		if !bytes.Contains(data, []byte(`"signature`)) {
+			return data
+		}
+		var ev struct {
+			ContentBlock struct {
+				Type      string `json:"type"`
+				Signature string `json:"signature"`
+			} `json:"content_block"`
+			Delta struct {
+				Type      string `json:"type"`
+				Signature string `json:"signature"`
+			} `json:"delta"`
+		}
+		if json.Unmarshal(data, &ev) != nil {
+			return data
+		}
+		if ev.ContentBlock.Type == "thinking" {
+			thinkingSignatures.record(ev.ContentBlock.Signature, backend)
+		}
+		if ev.Delta.Type == "signature_delta" {
+			thinkingSignatures.record(ev.Delta.Signature, backend)
+		}
+		return data
+	}
+}
+
+// signatureJSONHook records signatures from a buffered response.
+func signatureJSONHook(backend string) jsonHook {
+	return func(msg map[string]interface{}) bool {
+		content, _ := msg["content"].([]interface{})
+		for _, item := range content {
+			block, ok := item.(map[string]interface{})
+			if !ok || block["type"] != "thinking" {
+				continue
+			}
+			sig, _ := block["signature"].(string)
+			thinkingSignatures.record(sig, backend)
+		}
+		return false
+	}
+}
+
+// applyThinkingCompat adapts thinking content in the conversation history to
+// what the target backend accepts:
+//   - signed thinking issued by the target backend is kept;
+//   - signed thinking issued by another backend is dropped;
+//   - signed thinking of unknown origin is kept only for Anthropic (which
+//     issued it before this process started, or will reject it itself);
+//   - unsigned thinking is converted to text or dropped (unsignedMode);
+//   - redacted_thinking is kept only for Anthropic.
+//
+// Messages left empty are removed. Counts are added to stats under "compat:*".
+func applyThinkingCompat(data map[string]interface{}, backend, unsignedMode string, stats sanitizeStats) {
+	messages, ok := data["messages"].([]interface{})
+	if !ok {
+		return
+	}
+
+	anyChanged := false
+	result := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		msgMap, ok := msg.(map[string]interface{})
+		if !ok {
+			result = append(result, msg)
+			continue
+		}
+		contentArr, isArray := msgMap["content"].([]interface{})
+		if !isArray {
+			result = append(result, msg)
+			continue
+		}
+
+		changed := false
+		filtered := make([]interface{}, 0, len(contentArr))
+		for _, block := range contentArr {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				filtered = append(filtered, block)
+				continue
+			}
+			switch blockMap["type"] {
+			case "thinking":
+				sig, _ := blockMap["signature"].(string)
+				if sig == "" {
+					changed = true
+					text, _ := blockMap["thinking"].(string)
+					if unsignedMode == unsignedThinkingDrop || text == "" {
+						stats["compat:unsigned_thinking_dropped"]++
+						continue
+					}
+					stats["compat:unsigned_thinking_to_text"]++
+					filtered = append(filtered, map[string]interface{}{"type": "text", "text": text})
+					continue
+				}
+				issuer := thinkingSignatures.lookup(sig)
+				if issuer == backend || (issuer == "" && backend == backendAnthropic) {
+					filtered = append(filtered, block)
+					continue
+				}
+				changed = true
+				if issuer == "" {
+					stats["compat:unknown_thinking_dropped"]++
+				} else {
+					stats["compat:foreign_thinking_dropped"]++
+				}
+			case "redacted_thinking":
+				if backend == backendAnthropic {
+					filtered = append(filtered, block)
+					continue
+				}
+				changed = true
+				stats["compat:redacted_thinking_dropped"]++
+			default:
+				filtered = append(filtered, block)
+			}
+		}
+
+		if !changed {
+			result = append(result, msg)
+			continue
+		}
+		anyChanged = true
+		if len(filtered) == 0 {
+			stats["empty_message"]++
+			continue
+		}
+		newMsg := make(map[string]interface{}, len(msgMap))
+		for k, v := range msgMap {
+			newMsg[k] = v
+		}
+		newMsg["content"] = filtered
+		result = append(result, newMsg)
+	}
+	if !anyChanged {
+		return
+	}
+	data["messages"] = result
+	disableThinkingIfUnsupported(data, stats)
+}
+
+// disableThinkingIfUnsupported turns off extended thinking when the final
+// assistant tool-use turn no longer starts with a thinking block. With
+// thinking enabled, a tool-use continuation must start with the original
+// thinking block, so a history produced by another backend would otherwise
+// be rejected.
+func disableThinkingIfUnsupported(data map[string]interface{}, stats sanitizeStats) {
+	thinking, ok := data["thinking"].(map[string]interface{})
+	if !ok || thinking["type"] != "enabled" {
+		return
+	}
+	messages, _ := data["messages"].([]interface{})
+	for i := len(messages) - 1; i >= 0; i-- {
+		msgMap, ok := messages[i].(map[string]interface{})
+		if !ok || msgMap["role"] != "assistant" {
+			continue
+		}
+		content, ok := msgMap["content"].([]interface{})
+		if !ok || len(content) == 0 {
+			return
+		}
+		first, _ := content[0].(map[string]interface{})
+		if first["type"] == "thinking" || first["type"] == "redacted_thinking" {
+			return
+		}
+		hasToolUse := false
+		for _, block := range content {
+			if b, ok := block.(map[string]interface{}); ok && b["type"] == "tool_use" {
+				hasToolUse = true
+				break
+			}
+		}
+		if hasToolUse {
+			delete(data, "thinking")
+			stats["compat:thinking_disabled"]++
+		}
+		return
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+// withSignatureRegistry swaps in a fresh registry for the duration of a test.
+func withSignatureRegistry(t *testing.T, maxSize int) *signatureRegistry {
+	t.Helper()
+	old := thinkingSignatures
+	thinkingSignatures = newSignatureRegistry(maxSize)
+	t.Cleanup(func() { thinkingSignatures = old })
+	return thinkingSignatures
+}
+
+func TestBackendFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		modeConfig *ModeConfig
+		expected   string
+	}{
+		{"claude defaults to anthropic", "claude", nil, backendAnthropic},
+		{"other modes default to gemini", "antigravity", &ModeConfig{}, backendGemini},
+		{"explicit backend wins", "my-claude-proxy", &ModeConfig{Backend: backendAnthropic}, backendAnthropic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backendFor(tt.mode, tt.modeConfig); got != tt.expected {
+				t.Errorf("backendFor(%q) = %q, want %q", tt.mode, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSignatureRegistry_EvictsOldest(t *testing.T) {
+	r := newSignatureRegistry(2)
+	r.record("a", backendAnthropic)
+	r.record("b", backendGemini)
+	r.record("c", backendGemini)
+
+	if got := r.lookup("a"); got != "" {
+		t.Errorf("oldest entry should be evicted, got %q", got)
+	}
+	if got := r.lookup("b"); got != backendGemini {
+		t.Errorf("lookup(b) = %q, want gemini", got)
+	}
+	if got := r.lookup("c"); got != backendGemini {
+		t.Errorf("lookup(c) = %q, want gemini", got)
+	}
+	if r.size() != 2 {
+		t.Errorf("size = %d, want 2", r.size())
+	}
+}
+
+func TestResponseTap_RecordsSignatures(t *testing.T) {
+	reg := withSignatureRegistry(t, 10)
+
+	stream := "event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":"","signature":""}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"claude-sig"}}` + "\n\n"
+	resp := newTestResponse("text/event-stream", stream)
+	if err := buildResponseTap(&routeInfo{backend: backendAnthropic}, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	io.ReadAll(resp.Body)
+
+	resp = newTestResponse("application/json", `{"content":[{"type":"thinking","thinking":"t","signature":"gemini-sig"}]}`)
+	if err := buildResponseTap(&routeInfo{backend: backendGemini}, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	resp = newTestResponse("application/json", `{"content":[{"type":"thinking","thinking":"t","signature":"error-sig"}]}`)
+	if err := buildResponseTap(&routeInfo{backend: backendGemini}, 500).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := reg.lookup("claude-sig"); got != backendAnthropic {
+		t.Errorf("streamed signature issuer = %q, want anthropic", got)
+	}
+	if got := reg.lookup("gemini-sig"); got != backendGemini {
+		t.Errorf("buffered signature issuer = %q, want gemini", got)
+	}
+	if got := reg.lookup("error-sig"); got != "" {
+		t.Errorf("signatures from error responses must not be recorded, got %q", got)
+	}
+}
+
+func TestApplyThinkingCompat(t *testing.T) {
+	reg := withSignatureRegistry(t, 10)
+	reg.record("claude-sig", backendAnthropic)
+	reg.record("gemini-sig", backendGemini)
+
+	body := `{"messages": [
+		{"role": "assistant", "content": [
+			{"type": "thinking", "thinking": "c", "signature": "claude-sig"},
+			{"type": "thinking", "thinking": "g", "signature": "gemini-sig"},
+			{"type": "thinking", "thinking": "u", "signature": "unknown-sig"},
+			{"type": "thinking", "thinking": "plain reasoning"},
+			{"type": "redacted_thinking", "data": "r"},
+			{"type": "text", "text": "answer"}
+		]}
+	]}`
+
+	tests := []struct {
+		name     string
+		backend  string
+		unsigned string
+		content  string
+		stats    sanitizeStats
+	}{
+		{
+			name:     "anthropic target",
+			backend:  backendAnthropic,
+			unsigned: unsignedThinkingText,
+			content: `[
+				{"type": "thinking", "thinking": "c", "signature": "claude-sig"},
+				{"type": "thinking", "thinking": "u", "signature": "unknown-sig"},
+				{"type": "text", "text": "plain reasoning"},
+				{"type": "redacted_thinking", "data": "r"},
+				{"type": "text", "text": "answer"}
+			]`,
+			stats: sanitizeStats{"compat:foreign_thinking_dropped": 1, "compat:unsigned_thinking_to_text": 1},
+		},
+		{
+			name:     "gemini target",
+			backend:  backendGemini,
+			unsigned: unsignedThinkingDrop,
+			content: `[
+				{"type": "thinking", "thinking": "g", "signature": "gemini-sig"},
+				{"type": "text", "text": "answer"}
+			]`,
+			stats: sanitizeStats{
+				"compat:foreign_thinking_dropped":  1,
+				"compat:unknown_thinking_dropped":  1,
+				"compat:unsigned_thinking_dropped": 1,
+				"compat:redacted_thinking_dropped": 1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mustParse(t, body)
+			stats := sanitizeStats{}
+			applyThinkingCompat(data, tt.backend, tt.unsigned, stats)
+
+			want := mustParse(t, `{"messages": [{"role": "assistant", "content": `+tt.content+`}]}`)
+			if !reflect.DeepEqual(data, want) {
+				t.Errorf("messages = %v\nwant %v", data["messages"], want["messages"])
+			}
+			if !reflect.DeepEqual(stats, tt.stats) {
+				t.Errorf("stats = %v, want %v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestApplyThinkingCompat_DisablesThinkingForForeignToolTurn(t *testing.T) {
+	withSignatureRegistry(t, 10)
+
+	data := mustParse(t, `{
+		"thinking": {"type": "enabled", "budget_tokens": 1024},
+		"messages": [
+			{"role": "user", "content": "list files"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "t", "signature": "gemini-sig"},
+				{"type": "tool_use", "id": "t1", "name": "Bash", "input": {}}
+			]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]}
+		]
+	}`)
+	thinkingSignatures.record("gemini-sig", backendGemini)
+
+	stats := sanitizeStats{}
+	applyThinkingCompat(data, backendAnthropic, unsignedThinkingText, stats)
+
+	if _, ok := data["thinking"]; ok {
+		t.Error("thinking should be disabled when the tool-use turn lost its thinking block")
+	}
+	if stats["compat:thinking_disabled"] != 1 {
+		t.Errorf("stats = %v", stats)
+	}
+}
+
+func TestApplyThinkingCompat_NoChangesLeavesRequestAlone(t *testing.T) {
+	withSignatureRegistry(t, 10)
+
+	data := mustParse(t, `{
+		"thinking": {"type": "enabled", "budget_tokens": 1024},
+		"messages": [
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "t1", "name": "Bash", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "t1", "content": "ok"}]}
+		]
+	}`)
+
+	stats := sanitizeStats{}
+	applyThinkingCompat(data, backendAnthropic, unsignedThinkingText, stats)
+
+	if _, ok := data["thinking"]; !ok {
+		t.Error("thinking should be left alone when compat made no changes")
+	}
+	if len(stats) != 0 {
+		t.Errorf("stats = %v, want none", stats)
+	}
+}
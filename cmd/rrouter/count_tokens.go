@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// count_tokens handling (ModeConfig.CountTokens).
+const (
+	countTokensRewrite = "rewrite" // forward with the mode's rewriting applied (default)
+	countTokensForward = "forward" // forward the body unchanged
+	countTokensLocal   = "local"   // answer with a local estimate, never forwarded
+)
+
+const countTokensPath = "/v1/messages/count_tokens"
+
+func validCountTokens(mode string) bool {
+	switch mode {
+	case "", countTokensRewrite, countTokensForward, countTokensLocal:
+		return true
+	}
+	return false
+}
+
+func validateCountTokens(mode, modeName string) {
+	if !validCountTokens(mode) {
+		log.Printf("[WARN] Mode %q: unknown countTokens %q, using %q", modeName, mode, countTokensRewrite)
+	}
+}
+
+// isAuxiliaryPath reports whether path is an auxiliary endpoint whose
+// outcome must not count toward auto-mode or pool health.
+func isAuxiliaryPath(path string) bool {
+	return strings.TrimSuffix(path, "/") == countTokensPath
+}
+
+// serveCountTokens handles a count_tokens request for target. It bypasses
+// auto retry, hedging, shadowing and health accounting: a backend that
+// doesn't implement token counting is not a failing backend.
+func serveCountTokens(upstreams *upstreamSet, w http.ResponseWriter, r *http.Request, bodyBytes []byte, target, sessKey string, reqNum uint64) {
+	mode := countTokensRewrite
+	if mc, ok := appConfig.Modes[target]; ok && validCountTokens(mc.CountTokens) && mc.CountTokens != "" {
+		mode = mc.CountTokens
+	}
+
+	var attempt *http.Request
+	switch mode {
+	case countTokensLocal:
+		writeLocalTokenCount(w, bodyBytes, reqNum)
+		return
+	case countTokensForward:
+		attempt = withBody(r, bodyBytes)
+	default:
+		var err error
+		attempt, _, err = prepareAttempt(r, bodyBytes, target, sessKey)
+		if err != nil {
+			// e.g. rejected by context routing: the client is asking how big
+			// the request is, so answer rather than refuse
+			log.Printf("[Req #%d] count_tokens: %v, answering locally", reqNum, err)
+			writeLocalTokenCount(w, bodyBytes, reqNum)
+			return
+		}
+	}
+
+	serveDirect(upstreams, w, attempt, target, reqNum, "count_tokens "+mode)
+}
+
+// writeLocalTokenCount answers count_tokens with estimateRequestTokens.
+func writeLocalTokenCount(w http.ResponseWriter, bodyBytes []byte, reqNum uint64) {
+	var data map[string]interface{}
+	if err := json.Unmarshal(bodyBytes, &data); err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON body")
+		return
+	}
+	tokens := estimateRequestTokens(data)
+	log.Printf("[Req #%d] count_tokens: local estimate %d", reqNum, tokens)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"input_tokens": tokens})
+}
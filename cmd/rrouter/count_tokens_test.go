@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeCountTokens(t *testing.T) {
+	const body = `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hello there"}]}`
+
+	tests := []struct {
+		name        string
+		countTokens string
+		wantModel   string // model seen upstream; "" means not forwarded
+		wantStatus  int
+	}{
+		{name: "default rewrites", wantModel: "gemini-pro", wantStatus: http.StatusNotFound},
+		{name: "forward", countTokens: countTokensForward, wantModel: "claude-sonnet-4", wantStatus: http.StatusNotFound},
+		{name: "local", countTokens: countTokensLocal, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotModel string
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					Model string `json:"model"`
+				}
+				b, _ := io.ReadAll(r.Body)
+				json.Unmarshal(b, &req)
+				gotModel = req.Model
+				http.Error(w, `{"type":"error","error":{"type":"not_found_error","message":"not supported"}}`, http.StatusNotFound)
+			}))
+			defer backend.Close()
+
+			cfg := &Config{Modes: map[string]ModeConfig{
+				"gemini": {
+					Mappings:    []ModelMapping{{Match: "claude-sonnet-*", Rewrite: "gemini-pro"}},
+					CountTokens: tt.countTokens,
+				},
+			}}
+			withAppConfig(t, cfg)
+			upstreams, err := newUpstreamSet(cfg, backend.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, countTokensPath, strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			serveCountTokens(upstreams, rec, r, []byte(body), "gemini", "", 1)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotModel != tt.wantModel {
+				t.Errorf("upstream model = %q, want %q", gotModel, tt.wantModel)
+			}
+			if tt.countTokens == countTokensLocal {
+				var resp struct {
+					InputTokens int `json:"input_tokens"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.InputTokens <= 0 {
+					t.Errorf("unexpected local answer %s (%v)", rec.Body.String(), err)
+				}
+			}
+		})
+	}
+}
+
+func TestIsAuxiliaryPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/v1/messages/count_tokens", true},
+		{"/v1/messages/count_tokens/", true},
+		{"/v1/messages", false},
+		{"/v1/models", false},
+	}
+	for _, tt := range tests {
+		if got := isAuxiliaryPath(tt.path); got != tt.want {
+			t.Errorf("isAuxiliaryPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
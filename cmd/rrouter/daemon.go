@@ -20,6 +20,9 @@ var (
 	rrouterDir string
 	pidFile    string
 	modeFile   string
+
+	// cliProfile is the profile selected with --profile ("" = default).
+	cliProfile string
 )
 
 func init() {
@@ -215,8 +218,18 @@ func cmdRestart() {
 	cmdStart()
 }
 
-// cmdStatus shows the current daemon and mode status.
-func cmdStatus() {
+// cmdStatus shows the current daemon and mode status; --agents adds the
+// daemon's per-agent statistics.
+func cmdStatus(args []string) {
+	showAgents := false
+	for _, arg := range args {
+		if arg != "--agents" {
+			fmt.Fprintf(os.Stderr, "[rrouter] Unknown status option: %s\n", arg)
+			os.Exit(1)
+		}
+		showAgents = true
+	}
+
 	fmt.Println()
 	fmt.Println("===========================================")
 	fmt.Println("  rrouter Status")
@@ -243,6 +256,9 @@ func cmdStatus() {
 			fmt.Printf("  Mode:        Unknown: %s\n", mode)
 		}
 	}
+	if cliProfile == "" {
+		showScheduleStatus(loadConfigWithDefaults(), time.Now())
+	}
 	fmt.Println()
 
 	// Service status
@@ -267,6 +283,15 @@ func cmdStatus() {
 		fmt.Printf("  cliproxyapi (:%d):   Not running\n", 8317)
 	}
 
+	if showAgents {
+		fmt.Println()
+		if isRunning() {
+			showAgentStats()
+		} else {
+			fmt.Println("  Agent stats unavailable (daemon not running)")
+		}
+	}
+
 	fmt.Println()
 	fmt.Println("===========================================")
 	fmt.Println()
@@ -299,7 +324,7 @@ func isCliproxyapiRunning() bool {
 
 // showAutoSwitchStatus fetches and displays auto-switch status from /health.
 func showAutoSwitchStatus() {
-	resp, err := http.Get("http://localhost:8316/health")
+	resp, err := http.Get(healthURL())
 	if err != nil {
 		return
 	}
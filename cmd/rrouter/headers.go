@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Header rule operations.
+const (
+	headerSet         = "set"
+	headerAppend      = "append"
+	headerRemove      = "remove"
+	headerRemoveValue = "remove_value" // drop matching items from a comma-separated list
+)
+
+// Debug response headers describing how a request was routed.
+const (
+	headerRRouterMode   = "X-RRouter-Mode"   // mode in effect (may be "auto")
+	headerRRouterTarget = "X-RRouter-Target" // concrete mode that served the request
+	headerRRouterModel  = "X-RRouter-Model"  // model sent upstream
+)
+
+// HeaderRule edits a request header before it is forwarded, e.g. stripping
+// anthropic-beta flags a Gemini-backed upstream rejects:
+//
+//	{"op": "remove_value", "name": "anthropic-beta", "value": "context-1m-*"}
+type HeaderRule struct {
+	Op    string `json:"op"`
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"` // glob for remove_value
+}
+
+// applyHeaderRules applies rules to h in order.
+func applyHeaderRules(h http.Header, rules []HeaderRule) {
+	for _, rule := range rules {
+		switch rule.Op {
+		case headerSet:
+			h.Set(rule.Name, rule.Value)
+		case headerAppend:
+			h.Add(rule.Name, rule.Value)
+		case headerRemove:
+			h.Del(rule.Name)
+		case headerRemoveValue:
+			removeHeaderValue(h, rule.Name, rule.Value)
+		}
+	}
+}
+
+// removeHeaderValue drops the comma-separated items of header name that
+// match pattern, deleting the header once nothing is left.
+func removeHeaderValue(h http.Header, name, pattern string) {
+	var kept []string
+	for _, v := range h.Values(name) {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" && !matchModel(pattern, item) {
+				kept = append(kept, item)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		h.Del(name)
+		return
+	}
+	h.Set(name, strings.Join(kept, ","))
+}
+
+func validateHeaderRules(rules []HeaderRule, modeName string) {
+	for i, rule := range rules {
+		switch rule.Op {
+		case headerSet, headerAppend, headerRemove, headerRemoveValue:
+		default:
+			log.Printf("[WARN] Mode %q: headers[%d] has unknown op %q", modeName, i, rule.Op)
+		}
+		if rule.Name == "" {
+			log.Printf("[WARN] Mode %q: headers[%d] has no name", modeName, i)
+		}
+	}
+}
+
+// setRoutingHeaders adds the X-RRouter-* debug headers to an upstream
+// response served by mode; model is "" when the body was not rewritten.
+func setRoutingHeaders(resp *http.Response, mode, model string) {
+	if intent, ok := resp.Request.Context().Value(intentKey).(string); ok {
+		resp.Header.Set(headerRRouterMode, intent)
+	}
+	resp.Header.Set(headerRRouterTarget, mode)
+	if model != "" {
+		resp.Header.Set(headerRRouterModel, model)
+	}
+}
+
+// attemptMode returns the mode whose upstream r is sent to: the routeInfo of
+// a rewritten attempt, else the target serveDirect recorded.
+func attemptMode(r *http.Request) (string, bool) {
+	if info, ok := r.Context().Value(routeInfoKey).(*routeInfo); ok {
+		return info.mode, true
+	}
+	mode, ok := r.Context().Value(targetKey).(string)
+	return mode, ok
+}
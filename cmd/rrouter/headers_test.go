@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyHeaderRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    http.Header
+		rules []HeaderRule
+		want  http.Header
+	}{
+		{
+			name:  "set and append",
+			in:    http.Header{"X-Route": {"a"}},
+			rules: []HeaderRule{{Op: headerSet, Name: "x-route", Value: "b"}, {Op: headerAppend, Name: "X-Extra", Value: "1"}, {Op: headerAppend, Name: "X-Extra", Value: "2"}},
+			want:  http.Header{"X-Route": {"b"}, "X-Extra": {"1", "2"}},
+		},
+		{
+			name:  "remove",
+			in:    http.Header{"Anthropic-Beta": {"x"}, "Keep": {"y"}},
+			rules: []HeaderRule{{Op: headerRemove, Name: "anthropic-beta"}},
+			want:  http.Header{"Keep": {"y"}},
+		},
+		{
+			name:  "remove matching values",
+			in:    http.Header{"Anthropic-Beta": {"context-1m-2025-08-07, fine-grained-tool-streaming-2025-05-14", "interleaved-thinking-2025-05-14"}},
+			rules: []HeaderRule{{Op: headerRemoveValue, Name: "anthropic-beta", Value: "context-1m-*"}},
+			want:  http.Header{"Anthropic-Beta": {"fine-grained-tool-streaming-2025-05-14,interleaved-thinking-2025-05-14"}},
+		},
+		{
+			name:  "remove last value drops header",
+			in:    http.Header{"Anthropic-Beta": {"context-1m-2025-08-07"}},
+			rules: []HeaderRule{{Op: headerRemoveValue, Name: "anthropic-beta", Value: "context-1m-*"}},
+			want:  http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			applyHeaderRules(tt.in, tt.rules)
+			if !reflect.DeepEqual(tt.in, tt.want) {
+				t.Errorf("got %v, want %v", tt.in, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_HeaderRulesAndRoutingHeaders(t *testing.T) {
+	var gotBeta, gotRoute string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBeta, gotRoute = r.Header.Get("Anthropic-Beta"), r.Header.Get("X-Route")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gemini-pro"}`))
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		DefaultMode: "gemini",
+		Modes: map[string]ModeConfig{
+			"gemini": {
+				Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-pro"}},
+				Headers: []HeaderRule{
+					{Op: headerRemoveValue, Name: "anthropic-beta", Value: "context-1m-*"},
+					{Op: headerSet, Name: "X-Route", Value: "gemini"},
+				},
+			},
+		},
+	}
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4","messages":[]}`))
+	req.Header.Set("Anthropic-Beta", "context-1m-2025-08-07,oauth-2025-04-20")
+	rec := httptest.NewRecorder()
+	proxyHandler(upstreams)(rec, req)
+
+	if gotBeta != "oauth-2025-04-20" || gotRoute != "gemini" {
+		t.Errorf("upstream headers: anthropic-beta=%q x-route=%q", gotBeta, gotRoute)
+	}
+	want := map[string]string{headerRRouterMode: "gemini", headerRRouterTarget: "gemini", headerRRouterModel: "gemini-pro"}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+}
+
+func TestProxyHandler_HeaderRulesOnDirectRequests(t *testing.T) {
+	var gotBeta, gotRoute string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBeta, gotRoute = r.Header.Get("Anthropic-Beta"), r.Header.Get("X-Route")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"input_tokens":3}`))
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		DefaultMode: "gemini",
+		Modes: map[string]ModeConfig{
+			"gemini": {
+				CountTokens: countTokensForward,
+				Headers: []HeaderRule{
+					{Op: headerRemoveValue, Name: "anthropic-beta", Value: "context-1m-*"},
+					{Op: headerSet, Name: "X-Route", Value: "gemini"},
+				},
+			},
+		},
+		Routes: []RouteRule{{Path: "/v1/batches*", Action: routePassthrough}},
+	}
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, path := range []string{"/v1/batches", countTokensPath} {
+		gotBeta, gotRoute = "", ""
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"model":"claude-sonnet-4","messages":[]}`))
+		req.Header.Set("Anthropic-Beta", "context-1m-2025-08-07,oauth-2025-04-20")
+		rec := httptest.NewRecorder()
+		proxyHandler(upstreams)(rec, req)
+
+		if gotBeta != "oauth-2025-04-20" || gotRoute != "gemini" {
+			t.Errorf("%s: upstream headers: anthropic-beta=%q x-route=%q", path, gotBeta, gotRoute)
+		}
+		if got := rec.Header().Get(headerRRouterTarget); got != "gemini" {
+			t.Errorf("%s: %s = %q, want gemini", path, headerRRouterTarget, got)
+		}
+		if got := rec.Header().Get(headerRRouterMode); got != "gemini" {
+			t.Errorf("%s: %s = %q, want gemini", path, headerRRouterMode, got)
+		}
+	}
+}
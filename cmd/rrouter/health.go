@@ -53,7 +53,7 @@ func cmdHealth() {
 		fmt.Println("[rrouter] Checking health endpoint...")
 
 		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Get("http://localhost:8316/health")
+		resp, err := client.Get(healthURL())
 		if err != nil {
 			fmt.Printf("[FAIL] Cannot reach health endpoint: %v\n", err)
 			allOk = false
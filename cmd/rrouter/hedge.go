@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HedgeConfig sends a second copy of a slow request to another mode and
+// streams whichever responds first.
+type HedgeConfig struct {
+	AfterMs int    `json:"afterMs"`        // hedge if no response headers within this many ms
+	Mode    string `json:"mode,omitempty"` // fallback mode, default: the other auto target
+}
+
+// hedgeFor returns the hedge policy of the mapping that matches the
+// request's model in mode, or nil.
+func hedgeFor(bodyBytes []byte, mode string) *HedgeConfig {
+	mc, ok := appConfig.Modes[mode]
+	if !ok || len(bodyBytes) == 0 {
+		return nil
+	}
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(bodyBytes, &req) != nil {
+		return nil
+	}
+	m := findMapping(req.Model, &mc)
+	if m == nil || m.Hedge == nil || m.Hedge.AfterMs <= 0 {
+		return nil
+	}
+	return m.Hedge
+}
+
+// fallback returns the mode a hedged request is duplicated to.
+func (h *HedgeConfig) fallback(mode string) string {
+	if h.Mode != "" {
+		return h.Mode
+	}
+	return oppositeTarget(mode)
+}
+
+// hedgeRace lets the first leg with a successful response claim the client
+// writer; every other leg is cancelled.
+type hedgeRace struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	winner  *hedgeLeg
+	legs    []*hedgeLeg
+	claimed chan struct{}
+}
+
+// claim makes leg the winner if no leg has won yet.
+func (h *hedgeRace) claim(leg *hedgeLeg) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.winner != nil {
+		return h.winner == leg
+	}
+	h.winner = leg
+	for _, other := range h.legs {
+		if other != leg && !other.finished {
+			other.lost = true
+			other.cancel()
+		}
+	}
+	close(h.claimed)
+	return true
+}
+
+// add registers a leg; a leg started after the race was decided is
+// cancelled right away.
+func (h *hedgeRace) add(leg *hedgeLeg) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.legs = append(h.legs, leg)
+	if h.winner != nil {
+		leg.lost = true
+		leg.cancel()
+	}
+}
+
+// hedgeLegWriter is the "real" writer behind a leg's switchableResponseWriter.
+// It only sees successful responses (errors are buffered by the switchable
+// writer); the first one to write headers wins the client, the rest are
+// discarded.
+type hedgeLegWriter struct {
+	race   *hedgeRace
+	leg    *hedgeLeg
+	header http.Header
+	won    bool
+}
+
+func (hw *hedgeLegWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *hedgeLegWriter) WriteHeader(code int) {
+	if !hw.race.claim(hw.leg) {
+		return
+	}
+	hw.won = true
+	for k, v := range hw.header {
+		for _, vv := range v {
+			hw.race.w.Header().Add(k, vv)
+		}
+	}
+	hw.race.w.WriteHeader(code)
+}
+
+// Write never fails for a losing leg: a write error would make the reverse
+// proxy abort.
+func (hw *hedgeLegWriter) Write(p []byte) (int, error) {
+	if !hw.won {
+		return len(p), nil
+	}
+	return hw.race.w.Write(p)
+}
+
+func (hw *hedgeLegWriter) Flush() {
+	if hw.won {
+		if f, ok := hw.race.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// hedgeLeg is one copy of a hedged request.
+type hedgeLeg struct {
+	mode     string
+	req      *http.Request
+	result   *proxyResult
+	sw       *switchableResponseWriter
+	cancel   context.CancelFunc
+	lost     bool // cancelled because another leg won (guarded by hedgeRace.mu)
+	finished bool // attempt completed (guarded by hedgeRace.mu)
+	aborted  bool // the reverse proxy aborted mid-stream
+	out      attemptOutcome
+	done     chan struct{}
+}
+
+// start runs the leg in the background. A mid-stream abort is recovered here
+// and re-raised on the handler goroutine if this leg won (see serveHedged).
+func (leg *hedgeLeg) start(upstreams *upstreamSet, race *hedgeRace) {
+	ctx, cancel := context.WithCancel(leg.req.Context())
+	leg.req = leg.req.WithContext(ctx)
+	leg.cancel = cancel
+	leg.sw = newSwitchableResponseWriter(&hedgeLegWriter{race: race, leg: leg, header: make(http.Header)})
+	leg.done = make(chan struct{})
+	race.add(leg)
+
+	go func() {
+		defer close(leg.done)
+		defer cancel()
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					panic(v)
+				}
+				leg.aborted = true
+			}
+		}()
+		leg.out = serveAttempt(upstreams, leg.mode, leg.sw, leg.req, leg.result)
+		race.mu.Lock()
+		leg.finished = true
+		race.mu.Unlock()
+	}()
+}
+
+// serveHedged serves a prepared attempt for primary and, if it hasn't
+// produced a successful response within the hedge delay (or failed), a
+// second attempt for the fallback mode. The first successful response is
+// streamed to w; if none succeeds, the primary's error is returned. It
+// returns the legs that ran to completion (not cancelled as losers) and the
+// winner, if any.
+func serveHedged(upstreams *upstreamSet, w http.ResponseWriter, attempt *http.Request, result *proxyResult,
+	bodyBytes []byte, primary, sessKey string, hedge *HedgeConfig, reqNum uint64) (legs []*hedgeLeg, winner *hedgeLeg) {
+
+	race := &hedgeRace{w: w, claimed: make(chan struct{})}
+	first := &hedgeLeg{mode: primary, req: attempt, result: result}
+	first.start(upstreams, race)
+	all := []*hedgeLeg{first}
+
+	after := time.Duration(hedge.AfterMs) * time.Millisecond
+	timer := time.NewTimer(after)
+	defer timer.Stop()
+
+	select {
+	case <-race.claimed:
+	case <-first.done:
+		if first.sw.IsBuffered() || first.result.err != nil {
+			all = append(all, startHedgeLeg(upstreams, race, attempt, bodyBytes, hedge.fallback(primary), sessKey, reqNum, "failed"))
+		}
+	case <-timer.C:
+		all = append(all, startHedgeLeg(upstreams, race, attempt, bodyBytes, hedge.fallback(primary), sessKey, reqNum,
+			"no response after "+after.String()))
+	}
+
+	for _, leg := range all {
+		if leg != nil {
+			<-leg.done
+		}
+	}
+
+	race.mu.Lock()
+	winner = race.winner
+	for _, leg := range all {
+		if leg != nil && !leg.lost {
+			legs = append(legs, leg)
+		}
+	}
+	race.mu.Unlock()
+
+	if winner == nil {
+		// No success: return the primary's buffered error
+		first.sw.WriteTo(w)
+		return legs, nil
+	}
+	log.Printf("[HEDGE] Req #%d: %s won (HTTP %d, %s)", reqNum, winner.mode, winner.out.status, formatDuration(winner.out.elapsed))
+	if winner.aborted {
+		panic(http.ErrAbortHandler)
+	}
+	return legs, winner
+}
+
+// startHedgeLeg prepares and starts the fallback leg, or returns nil if the
+// request can't be rewritten for it.
+func startHedgeLeg(upstreams *upstreamSet, race *hedgeRace, r *http.Request, bodyBytes []byte,
+	mode, sessKey string, reqNum uint64, reason string) *hedgeLeg {
+
+	attempt, result, err := prepareAttempt(r, bodyBytes, mode, sessKey)
+	if err != nil {
+		log.Printf("[HEDGE] Req #%d: cannot hedge to %s: %v", reqNum, mode, err)
+		return nil
+	}
+	log.Printf("[HEDGE] Req #%d: primary %s, hedging to %s", reqNum, reason, mode)
+	leg := &hedgeLeg{mode: mode, req: attempt, result: result}
+	leg.start(upstreams, race)
+	return leg
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHedgeBackend returns a server that waits delay (or until cancelled)
+// before responding with status and body.
+func newHedgeBackend(t *testing.T, delay time.Duration, status int, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body) // lets the server notice the client going away
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func runHedged(t *testing.T, primary, fallback *httptest.Server) (*httptest.ResponseRecorder, []*hedgeLeg, *hedgeLeg) {
+	t.Helper()
+	cfg := &Config{Modes: map[string]ModeConfig{
+		"primary": {
+			Upstream: primary.URL,
+			Mappings: []ModelMapping{{
+				Match:   "claude-haiku-*",
+				Rewrite: "fast-model",
+				Hedge:   &HedgeConfig{AfterMs: 50, Mode: "fallback"},
+			}},
+		},
+		"fallback": {Upstream: fallback.URL},
+	}}
+	withAppConfig(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	body := []byte(`{"model":"claude-haiku-4-5"}`)
+	hedge := hedgeFor(body, "primary")
+	if hedge == nil {
+		t.Fatal("expected hedge policy for haiku")
+	}
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	attempt, result, err := prepareAttempt(req, body, "primary", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	legs, winner := serveHedged(upstreams, rec, attempt, result, body, "primary", "", hedge, 1)
+	return rec, legs, winner
+}
+
+func TestServeHedged(t *testing.T) {
+	tests := []struct {
+		name       string
+		primary    func(t *testing.T) *httptest.Server
+		fallback   func(t *testing.T) *httptest.Server
+		wantStatus int
+		wantBody   string
+		wantWinner string
+		wantLegs   int
+	}{
+		{
+			name:       "fast primary is not hedged",
+			primary:    func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 200, "primary") },
+			fallback:   func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 200, "fallback") },
+			wantStatus: 200, wantBody: "primary", wantWinner: "primary", wantLegs: 1,
+		},
+		{
+			name:       "slow primary loses to fallback",
+			primary:    func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 5*time.Second, 200, "primary") },
+			fallback:   func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 200, "fallback") },
+			wantStatus: 200, wantBody: "fallback", wantWinner: "fallback", wantLegs: 1,
+		},
+		{
+			name:       "failed primary hedges immediately",
+			primary:    func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 529, "overloaded") },
+			fallback:   func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 200, "fallback") },
+			wantStatus: 200, wantBody: "fallback", wantWinner: "fallback", wantLegs: 2,
+		},
+		{
+			name:       "both failing returns primary error",
+			primary:    func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 529, "overloaded") },
+			fallback:   func(t *testing.T) *httptest.Server { return newHedgeBackend(t, 0, 500, "broken") },
+			wantStatus: 529, wantBody: "overloaded", wantLegs: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			rec, legs, winner := runHedged(t, tt.primary(t), tt.fallback(t))
+
+			if rec.Code != tt.wantStatus || strings.TrimSpace(rec.Body.String()) != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", rec.Code, rec.Body.String(), tt.wantStatus, tt.wantBody)
+			}
+			gotWinner := ""
+			if winner != nil {
+				gotWinner = winner.mode
+			}
+			if gotWinner != tt.wantWinner {
+				t.Errorf("winner = %q, want %q", gotWinner, tt.wantWinner)
+			}
+			if len(legs) != tt.wantLegs {
+				t.Errorf("completed legs = %d, want %d", len(legs), tt.wantLegs)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("took %s, loser was not cancelled", elapsed)
+			}
+		})
+	}
+}
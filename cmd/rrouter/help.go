@@ -20,6 +20,7 @@ DAEMON COMMANDS:
   stop                Stop running daemon
   restart             Restart daemon
   status              Show current mode and daemon status
+  status --agents     Also show per-agent request statistics
 
 CONFIG COMMANDS:
   config              View current config.json
@@ -37,16 +38,30 @@ MODES:
   claude         Direct Claude OAuth passthrough (no rewriting)
   auto           Antigravity-first with automatic Claude fallback on errors
 
+PROFILES:
+  --profile <name>    Apply a mode command or status to a profile from
+                      config.json "profiles" (clients use /p/<name> or the
+                      profile's API keys)
+
+SCHEDULE:
+  config.json "schedule" switches the mode by time of day, e.g. rules
+  {"days": "mon-fri", "start": "09:00", "end": "18:00", "mode": "auto"}
+  with "default": "antigravity". A manual mode switch holds until the
+  next scheduled change. Schedule edits apply without a restart. Shown
+  in rrouter status.
+
 EXAMPLES:
   rrouter ag            # Switch to Antigravity mode
   rrouter claude        # Switch to Claude passthrough
   rrouter status        # Check current mode and daemon
   rrouter start         # Start the daemon
   rrouter --check       # Run health check
+  rrouter claude --profile work  # Switch only the "work" profile
 
 FILES:
   ~/.rrouter/mode         Current mode setting
   ~/.rrouter/config.json  Model rewriting rules
+  ~/.rrouter/profiles/    Per-profile mode files
   ~/.rrouter/rrouter.pid  Daemon PID file
   ~/.rrouter/logs/        Log files
 
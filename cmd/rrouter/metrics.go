@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsTable aggregates request outcomes per label combination (e.g. per
+// A/B variant). Tables are exposed in /health and, in Prometheus text
+// format, on /metrics.
+type statsTable struct {
+	name   string   // metric prefix, e.g. "rrouter_variant"
+	labels []string // label names, in order
+
+	mu   sync.Mutex
+	rows map[string]*statsRow
+}
+
+type statsRow struct {
+	values       []string
+	requests     uint64
+	errors       uint64
+	latencySum   time.Duration
+	inputTokens  int64
+	outputTokens int64
+}
+
+// attemptOutcome is what an upstream attempt produced, as seen by rrouter.
+type attemptOutcome struct {
+	status       int
+	failed       bool   // proxy error (timeout, connection failure)
+	streamError  string // 2xx stream that failed part-way (see streamMonitor)
+	elapsed      time.Duration
+	inputTokens  int64
+	outputTokens int64
+}
+
+// metricsTables lists every table served on /metrics, in output order.
+var metricsTables []*statsTable
+
+// newStatsTable creates a table and registers it for /metrics.
+func newStatsTable(name string, labels ...string) *statsTable {
+	t := &statsTable{name: name, labels: labels, rows: make(map[string]*statsRow)}
+	metricsTables = append(metricsTables, t)
+	return t
+}
+
+var variantStats = newStatsTable("rrouter_variant", "mode", "mapping", "variant")
+
+// observe records one request. Proxy errors, HTTP status >= 400 and failed
+// streams count as errors.
+func (t *statsTable) observe(o attemptOutcome, values ...string) {
+	key := strings.Join(values, "\x00")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	row, ok := t.rows[key]
+	if !ok {
+		row = &statsRow{values: values}
+		t.rows[key] = row
+	}
+	row.requests++
+	if o.failed || o.status >= 400 || o.streamError != "" {
+		row.errors++
+	}
+	row.latencySum += o.elapsed
+	row.inputTokens += o.inputTokens
+	row.outputTokens += o.outputTokens
+}
+
+// sortedRows returns a copy of the rows ordered by label values.
+func (t *statsTable) sortedRows() []statsRow {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rows := make([]statsRow, 0, len(t.rows))
+	for _, key := range sortedKeys(t.rows) {
+		rows = append(rows, *t.rows[key])
+	}
+	return rows
+}
+
+// HealthInfo returns per-row counters keyed by "/"-joined label values.
+func (t *statsTable) HealthInfo() map[string]interface{} {
+	info := make(map[string]interface{})
+	for _, row := range t.sortedRows() {
+		info[strings.Join(row.values, "/")] = map[string]interface{}{
+			"requests":     row.requests,
+			"errors":       row.errors,
+			"errorRate":    float64(row.errors) / float64(row.requests),
+			"avgLatencyMs": row.latencySum.Milliseconds() / int64(row.requests),
+			"inputTokens":  row.inputTokens,
+			"outputTokens": row.outputTokens,
+		}
+	}
+	return info
+}
+
+// writePrometheus writes the table in Prometheus text exposition format.
+func (t *statsTable) writePrometheus(w io.Writer) {
+	rows := t.sortedRows()
+	series := []struct {
+		suffix, kind string
+		value        func(statsRow) string
+	}{
+		{"_requests_total", "counter", func(r statsRow) string { return fmt.Sprint(r.requests) }},
+		{"_errors_total", "counter", func(r statsRow) string { return fmt.Sprint(r.errors) }},
+		{"_latency_seconds_sum", "counter", func(r statsRow) string { return fmt.Sprintf("%g", r.latencySum.Seconds()) }},
+		{"_input_tokens_total", "counter", func(r statsRow) string { return fmt.Sprint(r.inputTokens) }},
+		{"_output_tokens_total", "counter", func(r statsRow) string { return fmt.Sprint(r.outputTokens) }},
+	}
+	for _, s := range series {
+		fmt.Fprintf(w, "# TYPE %s%s %s\n", t.name, s.suffix, s.kind)
+		for _, row := range rows {
+			fmt.Fprintf(w, "%s%s{%s} %s\n", t.name, s.suffix, t.formatLabels(row.values), s.value(row))
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (t *statsTable) formatLabels(values []string) string {
+	pairs := make([]string, len(t.labels))
+	for i, name := range t.labels {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// serveMetricsHandler serves request counters in Prometheus text format.
+func serveMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# TYPE rrouter_requests_total counter\nrrouter_requests_total %d\n", requestCount.Load())
+	for _, t := range metricsTables {
+		t.writePrometheus(w)
+	}
+}
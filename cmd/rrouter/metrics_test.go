@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsTable(t *testing.T) {
+	table := &statsTable{name: "rrouter_test", labels: []string{"mode", "variant"}, rows: make(map[string]*statsRow)}
+	table.observe(attemptOutcome{status: 200, elapsed: 100 * time.Millisecond, inputTokens: 10, outputTokens: 5}, "antigravity", "a")
+	table.observe(attemptOutcome{status: 529, elapsed: 300 * time.Millisecond}, "antigravity", "a")
+	table.observe(attemptOutcome{failed: true, elapsed: 2 * time.Second}, "antigravity", `b"x`)
+
+	info := table.HealthInfo()
+	a, ok := info["antigravity/a"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("missing row, got %v", info)
+	}
+	if a["requests"] != uint64(2) || a["errors"] != uint64(1) || a["errorRate"] != 0.5 || a["avgLatencyMs"] != int64(200) || a["inputTokens"] != int64(10) {
+		t.Errorf("row = %v", a)
+	}
+
+	var buf bytes.Buffer
+	table.writePrometheus(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE rrouter_test_requests_total counter\n",
+		`rrouter_test_requests_total{mode="antigravity",variant="a"} 2` + "\n",
+		`rrouter_test_errors_total{mode="antigravity",variant="b\"x"} 1` + "\n",
+		`rrouter_test_latency_seconds_sum{mode="antigravity",variant="a"} 0.4` + "\n",
+		`rrouter_test_output_tokens_total{mode="antigravity",variant="a"} 5` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q\n%s", want, out)
+		}
+	}
+}
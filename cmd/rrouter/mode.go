@@ -3,13 +3,14 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 )
 
 // setMode writes the mode to the mode file.
 func setMode(mode string) error {
 	// Ensure directory exists
-	if err := os.MkdirAll(rrouterDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(modeFile), 0755); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 	if err := os.WriteFile(modeFile, []byte(mode), 0644); err != nil {
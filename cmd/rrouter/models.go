@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ModelsConfig controls GET /v1/models, which rrouter answers itself with
+// the client-facing model names the current mode accepts.
+type ModelsConfig struct {
+	// Names are extra client-facing names to list, typically concrete
+	// models for glob mappings (e.g. "claude-sonnet-4-5" for "claude-sonnet-*").
+	Names []string `json:"names,omitempty"`
+	// MergeUpstream adds the models listed by the mode's upstream. Default:
+	// true. A mode without concrete names always lists the upstream's.
+	MergeUpstream *bool `json:"mergeUpstream,omitempty"`
+}
+
+func (c *ModelsConfig) mergeUpstream() bool {
+	return c == nil || c.MergeUpstream == nil || *c.MergeUpstream
+}
+
+const upstreamModelsTimeout = 10 * time.Second
+
+// modelEntry is one item of the /v1/models listing. It carries both the
+// Anthropic ("type") and OpenAI ("object") shapes; the "rrouter" extension
+// field says where the model is routed.
+type modelEntry struct {
+	Type        string     `json:"type"`
+	Object      string     `json:"object"`
+	ID          string     `json:"id"`
+	DisplayName string     `json:"display_name"`
+	CreatedAt   string     `json:"created_at"`
+	Created     int64      `json:"created"`
+	OwnedBy     string     `json:"owned_by"`
+	RRouter     modelRoute `json:"rrouter"`
+}
+
+type modelRoute struct {
+	Mode     string   `json:"mode"`
+	Backend  string   `json:"backend"`
+	Targets  []string `json:"targets,omitempty"` // A/B rewrite targets
+	Mapping  string   `json:"mapping,omitempty"` // matching mapping pattern
+	Upstream bool     `json:"upstream,omitempty"`
+}
+
+// serveModelsHandler answers GET /v1/models for the current (resolved) mode
+// of the request's profile.
+func serveModelsHandler(upstreams *upstreamSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		prof := profileFor(r)
+		mode := prof.auto.resolveRouting(prof.mode())
+
+		var upstreamIDs []string
+		if appConfig.Models.mergeUpstream() || len(concreteModelNames(appConfig, mode)) == 0 {
+			ids, err := fetchUpstreamModels(upstreams.forMode(mode).peek(), r)
+			if err != nil {
+				log.Printf("[MODELS] Cannot list upstream models for %s: %v", mode, err)
+			}
+			upstreamIDs = ids
+		}
+
+		data := listModels(appConfig, mode, upstreamIDs)
+		resp := map[string]interface{}{
+			"object":   "list",
+			"data":     data,
+			"has_more": false,
+			"first_id": nil,
+			"last_id":  nil,
+		}
+		if len(data) > 0 {
+			resp["first_id"] = data[0].ID
+			resp["last_id"] = data[len(data)-1].ID
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// concreteModelNames returns mode's literal mapping patterns followed by
+// the configured names.
+func concreteModelNames(cfg *Config, mode string) []string {
+	var names []string
+	for _, m := range cfg.Modes[mode].Mappings {
+		if m.Match != "" && !isModelGlob(m.Match) {
+			names = append(names, m.Match)
+		}
+	}
+	if cfg.Models != nil {
+		names = append(names, cfg.Models.Names...)
+	}
+	return names
+}
+
+func isModelGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// listModels builds the listing for mode: concrete names and upstream
+// models, in that order without duplicates. A glob mapping none of those
+// names match is listed by its concrete rewrite targets, never by the glob.
+func listModels(cfg *Config, mode string, upstreamIDs []string) []modelEntry {
+	mc, ok := cfg.Modes[mode]
+	var modeConfig *ModeConfig
+	if ok {
+		modeConfig = &mc
+	}
+
+	seen := make(map[string]bool)
+	var data []modelEntry
+	add := func(id string, fromUpstream bool) {
+		if id == "" || seen[id] {
+			return
+		}
+		seen[id] = true
+		data = append(data, newModelEntry(id, mode, modeConfig, fromUpstream))
+	}
+	for _, id := range concreteModelNames(cfg, mode) {
+		add(id, false)
+	}
+	for _, id := range upstreamIDs {
+		add(id, true)
+	}
+
+	if modeConfig == nil {
+		return data
+	}
+	for _, m := range modeConfig.Mappings {
+		if !isModelGlob(m.Match) {
+			continue
+		}
+		covered := false
+		for id := range seen {
+			if matchModel(m.Match, id) {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		targets := []string{m.Rewrite}
+		for _, t := range m.Targets {
+			targets = append(targets, t.Rewrite)
+		}
+		for _, id := range targets {
+			if id == "" || isModelGlob(id) || seen[id] {
+				continue
+			}
+			seen[id] = true
+			entry := newModelEntry(id, mode, modeConfig, false)
+			entry.RRouter.Mapping = m.Match
+			data = append(data, entry)
+		}
+	}
+	return data
+}
+
+func newModelEntry(id, mode string, mc *ModeConfig, fromUpstream bool) modelEntry {
+	route := modelRoute{Mode: mode, Backend: id, Upstream: fromUpstream}
+	if m := findMapping(id, mc); m != nil {
+		route.Mapping = m.Match
+		route.Backend = m.Rewrite
+		for _, t := range m.Targets {
+			route.Targets = append(route.Targets, t.Rewrite)
+		}
+		if route.Backend == "" && len(route.Targets) > 0 {
+			route.Backend = route.Targets[0]
+		}
+	}
+	return modelEntry{
+		Type:        "model",
+		Object:      "model",
+		ID:          id,
+		DisplayName: id,
+		CreatedAt:   "1970-01-01T00:00:00Z",
+		OwnedBy:     "rrouter",
+		RRouter:     route,
+	}
+}
+
+// fetchUpstreamModels lists the model IDs of up, forwarding the client's
+// credentials.
+func fetchUpstreamModels(up *upstream, r *http.Request) ([]string, error) {
+	ctx, cancel := context.WithTimeout(r.Context(), upstreamModelsTimeout)
+	defer cancel()
+
+	req := r.Clone(ctx)
+	req.URL.Path = "/v1/models"
+	req.RequestURI = ""
+	req.Body = http.NoBody
+	req.ContentLength = 0
+
+	bw := &bufferResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+	up.proxy.ServeHTTP(bw, req)
+	if bw.statusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned HTTP %d", bw.statusCode)
+	}
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(bw.body.Bytes(), &list); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+// bufferResponseWriter collects a whole response in memory.
+type bufferResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (b *bufferResponseWriter) Header() http.Header         { return b.header }
+func (b *bufferResponseWriter) WriteHeader(code int)        { b.statusCode = code }
+func (b *bufferResponseWriter) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *bufferResponseWriter) Flush()                      {}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListModels(t *testing.T) {
+	cfg := &Config{
+		Modes: map[string]ModeConfig{
+			"gemini": {Mappings: []ModelMapping{
+				{Match: "claude-sonnet-*", Rewrite: "gemini-pro"},
+				{Match: "claude-haiku-*", Rewrite: "gemini-flash"},
+				{Match: "my-model", Targets: []RewriteTarget{{Rewrite: "a", Weight: 1}, {Rewrite: "b", Weight: 1}}},
+			}},
+		},
+		Models: &ModelsConfig{Names: []string{"claude-sonnet-4-5", "my-model"}},
+	}
+
+	tests := []struct {
+		name        string
+		mode        string
+		upstreamIDs []string
+		wantIDs     []string
+		wantBackend []string
+	}{
+		{
+			name:        "mappings and names",
+			mode:        "gemini",
+			wantIDs:     []string{"my-model", "claude-sonnet-4-5", "gemini-flash"},
+			wantBackend: []string{"a", "gemini-pro", "gemini-flash"},
+		},
+		{
+			name:        "merged with upstream",
+			mode:        "gemini",
+			upstreamIDs: []string{"claude-haiku-4-5", "gemini-pro", "my-model"},
+			wantIDs:     []string{"my-model", "claude-sonnet-4-5", "claude-haiku-4-5", "gemini-pro"},
+			wantBackend: []string{"a", "gemini-pro", "gemini-flash", "gemini-pro"},
+		},
+		{
+			name:        "unknown mode passes names through",
+			mode:        "claude",
+			wantIDs:     []string{"claude-sonnet-4-5", "my-model"},
+			wantBackend: []string{"claude-sonnet-4-5", "my-model"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := listModels(cfg, tt.mode, tt.upstreamIDs)
+			var ids, backends []string
+			for _, m := range data {
+				ids = append(ids, m.ID)
+				backends = append(backends, m.RRouter.Backend)
+				if m.RRouter.Mode != tt.mode {
+					t.Errorf("%s: mode = %q, want %q", m.ID, m.RRouter.Mode, tt.mode)
+				}
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("ids = %v, want %v", ids, tt.wantIDs)
+			}
+			if !reflect.DeepEqual(backends, tt.wantBackend) {
+				t.Errorf("backends = %v, want %v", backends, tt.wantBackend)
+			}
+		})
+	}
+
+	data := listModels(cfg, "gemini", nil)
+	if data[2].RRouter.Mapping != "claude-haiku-*" {
+		t.Errorf("uncovered glob target should name its mapping: %+v", data[2])
+	}
+	if !reflect.DeepEqual(data[0].RRouter.Targets, []string{"a", "b"}) {
+		t.Errorf("targets = %v, want [a b]", data[0].RRouter.Targets)
+	}
+}
+
+func TestServeModelsHandler_EmbeddedConfig(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[{"id":"claude-sonnet-4-5"},{"id":"gemini-3-flash-preview"}]}`))
+	}))
+	defer backend.Close()
+
+	cfg := loadEmbeddedConfig()
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		mode    string
+		wantIDs []string
+	}{
+		// No mappings: the upstream's own list
+		{"claude", []string{"claude-sonnet-4-5", "gemini-3-flash-preview"}},
+		// Globs are never IDs: uncovered ones list their rewrite targets
+		{"antigravity", []string{"claude-sonnet-4-5", "gemini-3-flash-preview", "gemini-claude-opus-4-5-thinking"}},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(filepath.Join(configWatcher.dir, "mode"), []byte(tt.mode), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		serveModelsHandler(upstreams)(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+
+		var resp struct {
+			Data []modelEntry `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: invalid response: %v", tt.mode, err)
+		}
+		var ids []string
+		for _, m := range resp.Data {
+			ids = append(ids, m.ID)
+		}
+		if !reflect.DeepEqual(ids, tt.wantIDs) {
+			t.Errorf("%s: ids = %v, want %v", tt.mode, ids, tt.wantIDs)
+		}
+	}
+}
+
+func TestFetchUpstreamModels(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":[{"id":"gemini-pro"},{"id":"gemini-flash"}]}`))
+	}))
+	defer backend.Close()
+
+	cfg := &Config{}
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	r.Header.Set("X-Api-Key", "secret")
+	ids, err := fetchUpstreamModels(upstreams.forMode("any").peek(), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"gemini-pro", "gemini-flash"}) {
+		t.Errorf("ids = %v", ids)
+	}
+
+	r.Header.Del("X-Api-Key")
+	if _, err := fetchUpstreamModels(upstreams.forMode("any").peek(), r); err == nil {
+		t.Error("expected error for HTTP 401")
+	}
+}
@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI chat/completions ingress. Requests are translated to Anthropic
+// Messages and served by the regular proxy handler (modes, mappings, agent
+// routing, auto failover); responses and SSE chunks are translated back.
+
+const openAIDefaultMaxTokens = 4096
+
+// openAIHandler serves /v1/chat/completions through next, the Anthropic
+// Messages handler.
+func openAIHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "method not allowed")
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "error reading request body")
+			return
+		}
+
+		var req map[string]interface{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON: "+err.Error())
+			return
+		}
+		msgReq, err := openAIToAnthropic(req)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+		msgBody, err := json.Marshal(msgReq)
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, "api_error", err.Error())
+			return
+		}
+
+		inner := r.Clone(r.Context())
+		inner.URL.Path = "/v1/messages"
+		inner.RequestURI = ""
+		inner.Body = io.NopCloser(bytes.NewReader(msgBody))
+		inner.ContentLength = int64(len(msgBody))
+		inner.Header.Del("Content-Length")
+		inner.Header.Set("Content-Type", "application/json")
+		if inner.Header.Get("Anthropic-Version") == "" {
+			inner.Header.Set("Anthropic-Version", "2023-06-01")
+		}
+		// OpenAI SDKs send "Authorization: Bearer <key>"; Anthropic expects
+		// x-api-key. Upstream gets one credential, x-api-key if both are set.
+		if key, ok := strings.CutPrefix(inner.Header.Get("Authorization"), "Bearer "); ok && key != "" {
+			if inner.Header.Get("X-Api-Key") == "" {
+				inner.Header.Set("X-Api-Key", key)
+			}
+			inner.Header.Del("Authorization")
+		}
+
+		model, _ := req["model"].(string)
+		stream, _ := req["stream"].(bool)
+		includeUsage := false
+		if opts, ok := req["stream_options"].(map[string]interface{}); ok {
+			includeUsage, _ = opts["include_usage"].(bool)
+		}
+
+		ow := newOpenAIResponseWriter(w, model, stream, includeUsage)
+		next(ow, inner)
+		ow.finish()
+	}
+}
+
+// writeOpenAIError writes an error body in OpenAI format.
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"code":    nil,
+		},
+	})
+}
+
+// ========== Request translation ==========
+
+// openAIToAnthropic translates a chat/completions request body into an
+// Anthropic Messages request body.
+func openAIToAnthropic(req map[string]interface{}) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	model, _ := req["model"].(string)
+	if model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	out["model"] = model
+
+	rawMessages, _ := req["messages"].([]interface{})
+	if len(rawMessages) == 0 {
+		return nil, fmt.Errorf("messages is required")
+	}
+
+	var system []string
+	var messages []map[string]interface{}
+	appendBlocks := func(role string, blocks []interface{}) {
+		if len(blocks) == 0 {
+			return
+		}
+		// Anthropic requires alternating roles: merge consecutive turns
+		if n := len(messages); n > 0 && messages[n-1]["role"] == role {
+			messages[n-1]["content"] = append(messages[n-1]["content"].([]interface{}), blocks...)
+			return
+		}
+		messages = append(messages, map[string]interface{}{"role": role, "content": blocks})
+	}
+
+	for i, raw := range rawMessages {
+		msg, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("messages[%d]: not an object", i)
+		}
+		role, _ := msg["role"].(string)
+		switch role {
+		case "system", "developer":
+			system = append(system, openAIText(msg["content"]))
+		case "user":
+			blocks, err := openAIContentBlocks(msg["content"])
+			if err != nil {
+				return nil, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			appendBlocks("user", blocks)
+		case "assistant":
+			var blocks []interface{}
+			if text := openAIText(msg["content"]); text != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+			calls, _ := msg["tool_calls"].([]interface{})
+			for _, c := range calls {
+				call, _ := c.(map[string]interface{})
+				fn, _ := call["function"].(map[string]interface{})
+				args, _ := fn["arguments"].(string)
+				var input interface{} = map[string]interface{}{}
+				if strings.TrimSpace(args) != "" {
+					if err := json.Unmarshal([]byte(args), &input); err != nil {
+						return nil, fmt.Errorf("messages[%d]: invalid tool call arguments: %w", i, err)
+					}
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call["id"],
+					"name":  fn["name"],
+					"input": input,
+				})
+			}
+			appendBlocks("assistant", blocks)
+		case "tool":
+			appendBlocks("user", []interface{}{map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": msg["tool_call_id"],
+				"content":     openAIText(msg["content"]),
+			}})
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, role)
+		}
+	}
+	if len(system) > 0 {
+		out["system"] = strings.Join(system, "\n\n")
+	}
+	outMessages := make([]interface{}, len(messages))
+	for i, m := range messages {
+		outMessages[i] = m
+	}
+	out["messages"] = outMessages
+
+	out["max_tokens"] = openAIDefaultMaxTokens
+	for _, key := range []string{"max_completion_tokens", "max_tokens"} {
+		if v, ok := req[key].(float64); ok {
+			out["max_tokens"] = int(v)
+			break
+		}
+	}
+	for _, key := range []string{"temperature", "top_p", "stream"} {
+		if v, ok := req[key]; ok {
+			out[key] = v
+		}
+	}
+	switch stop := req["stop"].(type) {
+	case string:
+		out["stop_sequences"] = []interface{}{stop}
+	case []interface{}:
+		out["stop_sequences"] = stop
+	}
+	if user, ok := req["user"].(string); ok && user != "" {
+		out["metadata"] = map[string]interface{}{"user_id": user}
+	}
+
+	if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 {
+		outTools := make([]interface{}, 0, len(tools))
+		for _, t := range tools {
+			tool, _ := t.(map[string]interface{})
+			fn, _ := tool["function"].(map[string]interface{})
+			if fn == nil {
+				continue
+			}
+			schema := fn["parameters"]
+			if schema == nil {
+				schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+			}
+			outTool := map[string]interface{}{"name": fn["name"], "input_schema": schema}
+			if desc, ok := fn["description"].(string); ok {
+				outTool["description"] = desc
+			}
+			outTools = append(outTools, outTool)
+		}
+		out["tools"] = outTools
+	}
+	if choice := openAIToolChoice(req["tool_choice"]); choice != nil {
+		if parallel, ok := req["parallel_tool_calls"].(bool); ok && !parallel {
+			choice["disable_parallel_tool_use"] = true
+		}
+		out["tool_choice"] = choice
+	}
+
+	return out, nil
+}
+
+func openAIToolChoice(v interface{}) map[string]interface{} {
+	switch c := v.(type) {
+	case string:
+		switch c {
+		case "auto":
+			return map[string]interface{}{"type": "auto"}
+		case "none":
+			return map[string]interface{}{"type": "none"}
+		case "required":
+			return map[string]interface{}{"type": "any"}
+		}
+	case map[string]interface{}:
+		if fn, ok := c["function"].(map[string]interface{}); ok {
+			return map[string]interface{}{"type": "tool", "name": fn["name"]}
+		}
+	}
+	return nil
+}
+
+// openAIText flattens OpenAI message content (string or parts) to text.
+func openAIText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var parts []string
+		for _, p := range c {
+			part, _ := p.(map[string]interface{})
+			if text, ok := part["text"].(string); ok {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// openAIContentBlocks translates user message content to Anthropic blocks.
+func openAIContentBlocks(content interface{}) ([]interface{}, error) {
+	switch c := content.(type) {
+	case string:
+		return []interface{}{map[string]interface{}{"type": "text", "text": c}}, nil
+	case []interface{}:
+		blocks := make([]interface{}, 0, len(c))
+		for _, p := range c {
+			part, _ := p.(map[string]interface{})
+			switch part["type"] {
+			case "text":
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": part["text"]})
+			case "image_url":
+				img, _ := part["image_url"].(map[string]interface{})
+				url, _ := img["url"].(string)
+				blocks = append(blocks, map[string]interface{}{"type": "image", "source": openAIImageSource(url)})
+			default:
+				return nil, fmt.Errorf("unsupported content part %v", part["type"])
+			}
+		}
+		return blocks, nil
+	}
+	return nil, fmt.Errorf("unsupported content")
+}
+
+// openAIImageSource converts an image URL (possibly a base64 data URL) to an
+// Anthropic image source.
+func openAIImageSource(url string) map[string]interface{} {
+	if rest, ok := strings.CutPrefix(url, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok && strings.HasSuffix(meta, ";base64") {
+			return map[string]interface{}{
+				"type":       "base64",
+				"media_type": strings.TrimSuffix(meta, ";base64"),
+				"data":       data,
+			}
+		}
+	}
+	return map[string]interface{}{"type": "url", "url": url}
+}
+
+// ========== Response translation ==========
+
+// openAIFinishReason maps an Anthropic stop_reason to an OpenAI finish_reason.
+func openAIFinishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal":
+		return "content_filter"
+	}
+	return "stop"
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+func (u anthropicUsage) openAI() map[string]interface{} {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return map[string]interface{}{
+		"prompt_tokens":     prompt,
+		"completion_tokens": u.OutputTokens,
+		"total_tokens":      prompt + u.OutputTokens,
+	}
+}
+
+// anthropicToOpenAI translates a buffered Messages response.
+func anthropicToOpenAI(body []byte, requestedModel string) ([]byte, error) {
+	var msg struct {
+		ID         string                   `json:"id"`
+		Model      string                   `json:"model"`
+		Content    []map[string]interface{} `json:"content"`
+		StopReason string                   `json:"stop_reason"`
+		Usage      anthropicUsage           `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, err
+	}
+
+	message := map[string]interface{}{"role": "assistant", "content": nil}
+	var text []string
+	var toolCalls []interface{}
+	for _, block := range msg.Content {
+		switch block["type"] {
+		case "text":
+			s, _ := block["text"].(string)
+			text = append(text, s)
+		case "tool_use":
+			args, _ := json.Marshal(block["input"])
+			toolCalls = append(toolCalls, map[string]interface{}{
+				"id":       block["id"],
+				"type":     "function",
+				"function": map[string]interface{}{"name": block["name"], "arguments": string(args)},
+			})
+		}
+	}
+	if len(text) > 0 {
+		message["content"] = strings.Join(text, "")
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	model := msg.Model
+	if model == "" {
+		model = requestedModel
+	}
+	return json.Marshal(map[string]interface{}{
+		"id":      "chatcmpl-" + msg.ID,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"message":       message,
+			"finish_reason": openAIFinishReason(msg.StopReason),
+		}},
+		"usage": msg.Usage.openAI(),
+	})
+}
+
+// anthropicErrorToOpenAI translates an error body; non-JSON bodies (e.g.
+// "Bad Gateway" from the proxy itself) become the message.
+func anthropicErrorToOpenAI(body []byte) (errType, message string) {
+	var e struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		return e.Error.Type, e.Error.Message
+	}
+	return "api_error", strings.TrimSpace(string(body))
+}
+
+// openAIResponseWriter receives the Anthropic response from the proxy
+// handler and writes its OpenAI translation to the client. SSE responses are
+// translated chunk by chunk; everything else is buffered until finish.
+type openAIResponseWriter struct {
+	real         http.ResponseWriter
+	model        string
+	stream       bool
+	includeUsage bool
+
+	header     http.Header
+	statusCode int
+	wroteHead  bool
+	streaming  bool
+	body       bytes.Buffer // buffered response, or incomplete SSE line
+
+	// stream state
+	event        string
+	id           string
+	created      int64
+	toolIndex    map[int]int // content block index -> tool_calls index
+	usage        anthropicUsage
+	finishReason string
+	done         bool
+}
+
+func newOpenAIResponseWriter(w http.ResponseWriter, model string, stream, includeUsage bool) *openAIResponseWriter {
+	return &openAIResponseWriter{
+		real:         w,
+		model:        model,
+		stream:       stream,
+		includeUsage: includeUsage,
+		header:       make(http.Header),
+		statusCode:   http.StatusOK,
+		created:      time.Now().Unix(),
+		toolIndex:    make(map[int]int),
+	}
+}
+
+func (o *openAIResponseWriter) Header() http.Header {
+	return o.header
+}
+
+func (o *openAIResponseWriter) WriteHeader(code int) {
+	if o.wroteHead {
+		return
+	}
+	o.wroteHead = true
+	o.statusCode = code
+	if code < 400 && isSSEHeader(o.header) {
+		o.streaming = true
+		o.copyHeaders()
+		o.real.Header().Set("Content-Type", "text/event-stream")
+		o.real.Header().Set("Cache-Control", "no-cache")
+		o.real.WriteHeader(code)
+	}
+}
+
+// copyHeaders forwards upstream headers that survive translation.
+func (o *openAIResponseWriter) copyHeaders() {
+	for k, v := range o.header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Length", "Content-Type", "Content-Encoding":
+			continue
+		}
+		o.real.Header()[k] = v
+	}
+}
+
+func (o *openAIResponseWriter) Write(p []byte) (int, error) {
+	if !o.wroteHead {
+		o.WriteHeader(http.StatusOK)
+	}
+	o.body.Write(p)
+	if o.streaming {
+		o.processLines()
+	}
+	return len(p), nil
+}
+
+func (o *openAIResponseWriter) Flush() {
+	if o.streaming {
+		if f, ok := o.real.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// finish writes a buffered response, or terminates an unfinished stream.
+func (o *openAIResponseWriter) finish() {
+	if o.streaming {
+		if o.body.Len() > 0 {
+			o.body.WriteByte('\n')
+			o.processLines()
+		}
+		o.writeDone()
+		return
+	}
+
+	body := o.body.Bytes()
+	if o.statusCode >= 400 {
+		errType, message := anthropicErrorToOpenAI(body)
+		o.copyHeaders()
+		writeOpenAIError(o.real, o.statusCode, errType, message)
+		return
+	}
+	out, err := anthropicToOpenAI(body, o.model)
+	if err != nil {
+		log.Printf("[OPENAI] Cannot translate response: %v", err)
+		writeOpenAIError(o.real, http.StatusBadGateway, "api_error", "invalid upstream response")
+		return
+	}
+	o.copyHeaders()
+	o.real.Header().Set("Content-Type", "application/json")
+	o.real.WriteHeader(o.statusCode)
+	o.real.Write(out)
+}
+
+func (o *openAIResponseWriter) processLines() {
+	for {
+		buf := o.body.Bytes()
+		idx := bytes.IndexByte(buf, '\n')
+		if idx < 0 {
+			return
+		}
+		line := strings.TrimRight(string(buf[:idx]), "\r")
+		o.body.Next(idx + 1)
+
+		switch {
+		case line == "":
+			o.event = ""
+		case strings.HasPrefix(line, "event:"):
+			o.event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			o.handleEvent(strings.TrimSpace(line[len("data:"):]))
+		}
+	}
+}
+
+// handleEvent translates one Anthropic SSE event into OpenAI chunks.
+func (o *openAIResponseWriter) handleEvent(data string) {
+	var ev struct {
+		Type    string `json:"type"`
+		Message struct {
+			ID    string         `json:"id"`
+			Model string         `json:"model"`
+			Usage anthropicUsage `json:"usage"`
+		} `json:"message"`
+		Index        int                    `json:"index"`
+		ContentBlock map[string]interface{} `json:"content_block"`
+		Delta        struct {
+			Type        string `json:"type"`
+			Text        string `json:"text"`
+			PartialJSON string `json:"partial_json"`
+			StopReason  string `json:"stop_reason"`
+		} `json:"delta"`
+		Usage anthropicUsage `json:"usage"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(data), &ev) != nil {
+		return
+	}
+	event := o.event
+	if event == "" {
+		event = ev.Type
+	}
+
+	switch event {
+	case "message_start":
+		o.id = ev.Message.ID
+		if ev.Message.Model != "" {
+			o.model = ev.Message.Model
+		}
+		o.usage = ev.Message.Usage
+		o.writeChunk(map[string]interface{}{"role": "assistant", "content": ""}, nil)
+	case "content_block_start":
+		if ev.ContentBlock["type"] == "tool_use" {
+			idx := len(o.toolIndex)
+			o.toolIndex[ev.Index] = idx
+			o.writeChunk(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+				"index":    idx,
+				"id":       ev.ContentBlock["id"],
+				"type":     "function",
+				"function": map[string]interface{}{"name": ev.ContentBlock["name"], "arguments": ""},
+			}}}, nil)
+		}
+	case "content_block_delta":
+		switch ev.Delta.Type {
+		case "text_delta":
+			o.writeChunk(map[string]interface{}{"content": ev.Delta.Text}, nil)
+		case "input_json_delta":
+			if idx, ok := o.toolIndex[ev.Index]; ok {
+				o.writeChunk(map[string]interface{}{"tool_calls": []interface{}{map[string]interface{}{
+					"index":    idx,
+					"function": map[string]interface{}{"arguments": ev.Delta.PartialJSON},
+				}}}, nil)
+			}
+		}
+	case "message_delta":
+		if ev.Delta.StopReason != "" {
+			o.finishReason = openAIFinishReason(ev.Delta.StopReason)
+		}
+		if ev.Usage.OutputTokens > 0 {
+			o.usage.OutputTokens = ev.Usage.OutputTokens
+		}
+	case "message_stop":
+		finish := o.finishReason
+		if finish == "" {
+			finish = "stop"
+		}
+		o.writeChunk(map[string]interface{}{}, finish)
+		if o.includeUsage {
+			o.writeJSON(map[string]interface{}{
+				"id":      "chatcmpl-" + o.id,
+				"object":  "chat.completion.chunk",
+				"created": o.created,
+				"model":   o.model,
+				"choices": []interface{}{},
+				"usage":   o.usage.openAI(),
+			})
+		}
+		o.writeDone()
+	case "error":
+		o.writeJSON(map[string]interface{}{
+			"error": map[string]interface{}{"message": ev.Error.Message, "type": ev.Error.Type, "code": nil},
+		})
+		o.writeDone()
+	}
+}
+
+func (o *openAIResponseWriter) writeChunk(delta map[string]interface{}, finishReason interface{}) {
+	o.writeJSON(map[string]interface{}{
+		"id":      "chatcmpl-" + o.id,
+		"object":  "chat.completion.chunk",
+		"created": o.created,
+		"model":   o.model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	})
+}
+
+func (o *openAIResponseWriter) writeJSON(v interface{}) {
+	if o.done {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	o.real.Write([]byte("data: "))
+	o.real.Write(b)
+	o.real.Write([]byte("\n\n"))
+}
+
+func (o *openAIResponseWriter) writeDone() {
+	if o.done {
+		return
+	}
+	o.real.Write([]byte("data: [DONE]\n\n"))
+	o.done = true
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIToAnthropic(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "system and user",
+			req: `{"model":"claude-sonnet-4","messages":[
+				{"role":"system","content":"be brief"},
+				{"role":"developer","content":[{"type":"text","text":"no emoji"}]},
+				{"role":"user","content":"hi"}],"max_tokens":100,"temperature":0.5,"stop":"END","user":"u1"}`,
+			want: `{"model":"claude-sonnet-4","system":"be brief\n\nno emoji","max_tokens":100,"temperature":0.5,
+				"stop_sequences":["END"],"metadata":{"user_id":"u1"},
+				"messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`,
+		},
+		{
+			name: "default max tokens and stream",
+			req:  `{"model":"m","messages":[{"role":"user","content":"hi"}],"stream":true,"max_completion_tokens":50}`,
+			want: `{"model":"m","max_tokens":50,"stream":true,"messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`,
+		},
+		{
+			name: "tool calls and results",
+			req: `{"model":"m","messages":[
+				{"role":"user","content":"weather?"},
+				{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+				{"role":"tool","tool_call_id":"call_1","content":"sunny"},
+				{"role":"user","content":"thanks"}],
+				"tools":[{"type":"function","function":{"name":"get_weather","description":"Get weather","parameters":{"type":"object"}}}],
+				"tool_choice":"required","parallel_tool_calls":false}`,
+			want: `{"model":"m","max_tokens":4096,"messages":[
+				{"role":"user","content":[{"type":"text","text":"weather?"}]},
+				{"role":"assistant","content":[{"type":"tool_use","id":"call_1","name":"get_weather","input":{"city":"Paris"}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"sunny"},{"type":"text","text":"thanks"}]}],
+				"tools":[{"name":"get_weather","description":"Get weather","input_schema":{"type":"object"}}],
+				"tool_choice":{"type":"any","disable_parallel_tool_use":true}}`,
+		},
+		{
+			name: "image parts",
+			req: `{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"what is this"},
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}},
+				{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}],"max_tokens":10}`,
+			want: `{"model":"m","max_tokens":10,"messages":[{"role":"user","content":[{"type":"text","text":"what is this"},
+				{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}},
+				{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}]}]}`,
+		},
+		{
+			name:    "missing model",
+			req:     `{"messages":[{"role":"user","content":"hi"}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown role",
+			req:     `{"model":"m","messages":[{"role":"function","content":"hi"}]}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := openAIToAnthropic(mustParse(t, tt.req))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Round-trip through JSON to compare with the expected body
+			b, _ := json.Marshal(got)
+			if gotMap, want := mustParse(t, string(b)), mustParse(t, tt.want); !reflect.DeepEqual(gotMap, want) {
+				t.Errorf("got  %s\nwant %s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicToOpenAI(t *testing.T) {
+	body := `{"id":"msg_1","model":"claude-sonnet-4","stop_reason":"tool_use",
+		"content":[{"type":"thinking","thinking":"hmm"},{"type":"text","text":"Checking."},
+		{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"Paris"}}],
+		"usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":7}}`
+	out, err := anthropicToOpenAI([]byte(body), "requested")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := mustParse(t, string(out))
+	if got["id"] != "chatcmpl-msg_1" || got["object"] != "chat.completion" || got["model"] != "claude-sonnet-4" {
+		t.Errorf("unexpected envelope: %s", out)
+	}
+	choice := got["choices"].([]interface{})[0].(map[string]interface{})
+	if choice["finish_reason"] != "tool_calls" {
+		t.Errorf("finish_reason = %v, want tool_calls", choice["finish_reason"])
+	}
+	msg := choice["message"].(map[string]interface{})
+	if msg["content"] != "Checking." {
+		t.Errorf("content = %v, want Checking.", msg["content"])
+	}
+	call := msg["tool_calls"].([]interface{})[0].(map[string]interface{})
+	fn := call["function"].(map[string]interface{})
+	if call["id"] != "toolu_1" || fn["name"] != "get_weather" || fn["arguments"] != `{"city":"Paris"}` {
+		t.Errorf("unexpected tool call: %v", call)
+	}
+	usage := got["usage"].(map[string]interface{})
+	if usage["prompt_tokens"] != 15.0 || usage["completion_tokens"] != 7.0 || usage["total_tokens"] != 22.0 {
+		t.Errorf("unexpected usage: %v", usage)
+	}
+}
+
+// sseChunks returns the data payloads of an OpenAI stream.
+func sseChunks(body string) []string {
+	var chunks []string
+	for _, line := range strings.Split(body, "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			chunks = append(chunks, data)
+		}
+	}
+	return chunks
+}
+
+func TestOpenAIHandler(t *testing.T) {
+	streamBody := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"model\":\"claude-sonnet-4\",\"usage\":{\"input_tokens\":3}}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"f\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{}\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":4}}\n\n" +
+		testMessageStop
+
+	tests := []struct {
+		name       string
+		req        string
+		status     int
+		respType   string
+		respBody   string
+		wantStatus int
+		check      func(t *testing.T, body string)
+	}{
+		{
+			name:       "non-streaming",
+			req:        `{"model":"claude-sonnet-4","messages":[{"role":"user","content":"hi"}]}`,
+			status:     http.StatusOK,
+			respType:   "application/json",
+			respBody:   `{"id":"msg_1","content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":2}}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body string) {
+				got := mustParse(t, body)
+				choice := got["choices"].([]interface{})[0].(map[string]interface{})
+				if choice["finish_reason"] != "stop" || choice["message"].(map[string]interface{})["content"] != "Hello" {
+					t.Errorf("unexpected response: %s", body)
+				}
+				if got["model"] != "claude-sonnet-4" {
+					t.Errorf("model = %v, want the requested model", got["model"])
+				}
+			},
+		},
+		{
+			name:       "upstream error",
+			req:        `{"model":"m","messages":[{"role":"user","content":"hi"}]}`,
+			status:     529,
+			respType:   "application/json",
+			respBody:   `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			wantStatus: 529,
+			check: func(t *testing.T, body string) {
+				e := mustParse(t, body)["error"].(map[string]interface{})
+				if e["type"] != "overloaded_error" || e["message"] != "Overloaded" {
+					t.Errorf("unexpected error body: %s", body)
+				}
+			},
+		},
+		{
+			name:       "invalid request",
+			req:        `{"model":"m"}`,
+			wantStatus: http.StatusBadRequest,
+			check: func(t *testing.T, body string) {
+				if mustParse(t, body)["error"] == nil {
+					t.Errorf("expected OpenAI error body, got %s", body)
+				}
+			},
+		},
+		{
+			name:       "streaming with tool call and usage",
+			req:        `{"model":"m","messages":[{"role":"user","content":"hi"}],"stream":true,"stream_options":{"include_usage":true}}`,
+			status:     http.StatusOK,
+			respType:   "text/event-stream",
+			respBody:   streamBody,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body string) {
+				chunks := sseChunks(body)
+				if len(chunks) != 7 || chunks[len(chunks)-1] != "[DONE]" {
+					t.Fatalf("unexpected chunks: %q", chunks)
+				}
+				delta := func(i int) map[string]interface{} {
+					c := mustParse(t, chunks[i])["choices"].([]interface{})[0].(map[string]interface{})
+					return c["delta"].(map[string]interface{})
+				}
+				if delta(0)["role"] != "assistant" || delta(1)["content"] != "Hi" {
+					t.Errorf("unexpected leading chunks: %q", chunks[:2])
+				}
+				call := delta(2)["tool_calls"].([]interface{})[0].(map[string]interface{})
+				if call["id"] != "toolu_1" || call["index"] != 0.0 {
+					t.Errorf("unexpected tool call chunk: %s", chunks[2])
+				}
+				args := delta(3)["tool_calls"].([]interface{})[0].(map[string]interface{})["function"].(map[string]interface{})
+				if args["arguments"] != "{}" {
+					t.Errorf("unexpected arguments chunk: %s", chunks[3])
+				}
+				last := mustParse(t, chunks[4])["choices"].([]interface{})[0].(map[string]interface{})
+				if last["finish_reason"] != "tool_calls" {
+					t.Errorf("finish_reason = %v, want tool_calls", last["finish_reason"])
+				}
+				usage := mustParse(t, chunks[5])["usage"].(map[string]interface{})
+				if usage["total_tokens"] != 7.0 {
+					t.Errorf("unexpected usage chunk: %s", chunks[5])
+				}
+			},
+		},
+		{
+			name:       "truncated stream",
+			req:        `{"model":"m","messages":[{"role":"user","content":"hi"}],"stream":true}`,
+			status:     http.StatusOK,
+			respType:   "text/event-stream",
+			respBody:   testMessageStart + "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"api_error\",\"message\":\"upstream stream ended unexpectedly\"}}\n\n",
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T, body string) {
+				chunks := sseChunks(body)
+				if len(chunks) != 3 || !strings.Contains(chunks[1], "upstream stream ended unexpectedly") || chunks[2] != "[DONE]" {
+					t.Errorf("unexpected chunks: %q", chunks)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			var gotBody map[string]interface{}
+			next := func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				b, _ := io.ReadAll(r.Body)
+				json.Unmarshal(b, &gotBody)
+				w.Header().Set("Content-Type", tt.respType)
+				w.WriteHeader(tt.status)
+				// Split writes to exercise partial SSE lines
+				for i := 0; i < len(tt.respBody); i += 7 {
+					io.WriteString(w, tt.respBody[i:min(i+7, len(tt.respBody))])
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(tt.req))
+			req.Header.Set("Authorization", "Bearer sk-test")
+			rec := httptest.NewRecorder()
+			openAIHandler(next)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.status != 0 {
+				if gotPath != "/v1/messages" {
+					t.Errorf("forwarded path = %q, want /v1/messages", gotPath)
+				}
+				if gotBody["messages"] == nil || gotBody["max_tokens"] == nil {
+					t.Errorf("forwarded body not translated: %v", gotBody)
+				}
+			}
+			tt.check(t, rec.Body.String())
+		})
+	}
+}
+
+func TestOpenAIHandler_Credentials(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth, key string
+		wantKey   string
+	}{
+		{"bearer only", "Bearer sk-bearer", "", "sk-bearer"},
+		{"both", "Bearer sk-bearer", "sk-key", "sk-key"},
+		{"api key only", "", "sk-key", "sk-key"},
+	}
+	for _, tt := range tests {
+		var gotKey, gotAuth string
+		next := func(w http.ResponseWriter, r *http.Request) {
+			gotKey, gotAuth = r.Header.Get("X-Api-Key"), r.Header.Get("Authorization")
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"id":"msg_1","content":[],"stop_reason":"end_turn"}`)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		if tt.key != "" {
+			req.Header.Set("X-Api-Key", tt.key)
+		}
+		openAIHandler(next)(httptest.NewRecorder(), req)
+
+		if gotKey != tt.wantKey || gotAuth != "" {
+			t.Errorf("%s: upstream x-api-key=%q authorization=%q, want %q and none", tt.name, gotKey, gotAuth, tt.wantKey)
+		}
+	}
+}
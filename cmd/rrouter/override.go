@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ModeOverrideConfig lets a single request pick its mode, bypassing
+// ~/.rrouter/mode, with an X-RRouter-Mode header or a "@mode" suffix on the
+// model name (e.g. "claude-sonnet-4-5@claude"). Only modes in Allow can be
+// selected; "auto" may be listed too.
+type ModeOverrideConfig struct {
+	Allow         []string `json:"allow"`
+	DisableHeader bool     `json:"disableHeader,omitempty"`
+	DisableSuffix bool     `json:"disableSuffix,omitempty"`
+}
+
+func (c *ModeOverrideConfig) allows(mode string) bool {
+	return c != nil && mode != "" && slices.Contains(c.Allow, mode)
+}
+
+func validateModeOverride(c *ModeOverrideConfig, cfg *Config) {
+	if c == nil {
+		return
+	}
+	for _, mode := range c.Allow {
+		if _, ok := cfg.Modes[mode]; !ok && mode != "auto" {
+			log.Printf("[WARN] modeOverride.allow: unknown mode %q", mode)
+		}
+	}
+}
+
+// requestModeOverride returns the mode a request selects for itself, or ""
+// if none, and the body to forward (with a recognised model suffix
+// stripped). The override header is always removed from r so it never
+// reaches the upstream.
+func requestModeOverride(cfg *ModeOverrideConfig, r *http.Request, bodyBytes []byte, reqNum uint64) (string, []byte) {
+	header := r.Header.Get(headerRRouterMode)
+	r.Header.Del(headerRRouterMode)
+
+	mode := ""
+	if header != "" && (cfg == nil || cfg.DisableHeader || !cfg.allows(header)) {
+		log.Printf("[Req #%d] Ignoring %s: %q is not an allowed override", reqNum, headerRRouterMode, header)
+	} else if header != "" {
+		mode = header
+		log.Printf("[Req #%d] Mode override: %s (header)", reqNum, mode)
+	}
+
+	if cfg == nil || cfg.DisableSuffix || len(bodyBytes) == 0 {
+		return mode, bodyBytes
+	}
+	var data map[string]interface{}
+	if json.Unmarshal(bodyBytes, &data) != nil {
+		return mode, bodyBytes
+	}
+	model, _ := data["model"].(string)
+	at := strings.LastIndex(model, "@")
+	// Only allowed modes count as suffixes: "@" also appears in real model
+	// names (e.g. Vertex "claude-3-5-sonnet@20240620")
+	if at < 0 || !cfg.allows(model[at+1:]) {
+		return mode, bodyBytes
+	}
+	data["model"] = model[:at]
+	body, err := json.Marshal(data)
+	if err != nil {
+		return mode, bodyBytes
+	}
+	if mode == "" {
+		mode = model[at+1:]
+		log.Printf("[Req #%d] Mode override: %s (model suffix)", reqNum, mode)
+	}
+	return mode, body
+}
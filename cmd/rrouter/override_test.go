@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestModeOverride(t *testing.T) {
+	cfg := &ModeOverrideConfig{Allow: []string{"claude", "auto"}}
+
+	tests := []struct {
+		name      string
+		cfg       *ModeOverrideConfig
+		header    string
+		model     string
+		wantMode  string
+		wantModel string
+	}{
+		{name: "no override", cfg: cfg, model: "claude-sonnet-4-5", wantModel: "claude-sonnet-4-5"},
+		{name: "header", cfg: cfg, header: "claude", model: "claude-sonnet-4-5", wantMode: "claude", wantModel: "claude-sonnet-4-5"},
+		{name: "header not allowed", cfg: cfg, header: "gemini", model: "claude-sonnet-4-5", wantModel: "claude-sonnet-4-5"},
+		{name: "suffix", cfg: cfg, model: "claude-sonnet-4-5@claude", wantMode: "claude", wantModel: "claude-sonnet-4-5"},
+		{name: "suffix auto", cfg: cfg, model: "claude-sonnet-4-5@auto", wantMode: "auto", wantModel: "claude-sonnet-4-5"},
+		{name: "header wins over suffix", cfg: cfg, header: "auto", model: "claude-sonnet-4-5@claude", wantMode: "auto", wantModel: "claude-sonnet-4-5"},
+		{name: "vertex-style model name untouched", cfg: cfg, model: "claude-3-5-sonnet@20240620", wantModel: "claude-3-5-sonnet@20240620"},
+		{name: "suffix disabled", cfg: &ModeOverrideConfig{Allow: []string{"claude"}, DisableSuffix: true}, model: "m@claude", wantModel: "m@claude"},
+		{name: "header disabled", cfg: &ModeOverrideConfig{Allow: []string{"claude"}, DisableHeader: true}, header: "claude", model: "m", wantModel: "m"},
+		{name: "not configured", header: "claude", model: "m@claude", wantModel: "m@claude"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"model":"` + tt.model + `","messages":[]}`
+			r := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+			if tt.header != "" {
+				r.Header.Set(headerRRouterMode, tt.header)
+			}
+			mode, out := requestModeOverride(tt.cfg, r, []byte(body), 1)
+			if mode != tt.wantMode {
+				t.Errorf("mode = %q, want %q", mode, tt.wantMode)
+			}
+			var data struct {
+				Model string `json:"model"`
+			}
+			json.Unmarshal(out, &data)
+			if data.Model != tt.wantModel {
+				t.Errorf("model = %q, want %q", data.Model, tt.wantModel)
+			}
+			if r.Header.Get(headerRRouterMode) != "" {
+				t.Error("override header should be removed before forwarding")
+			}
+		})
+	}
+}
+
+func TestProxyHandler_ModeOverride(t *testing.T) {
+	var gotModel, gotHeader string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &req)
+		gotModel, gotHeader = req.Model, r.Header.Get(headerRRouterMode)
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		DefaultMode: "gemini",
+		Modes: map[string]ModeConfig{
+			"gemini": {Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-pro"}}},
+			"claude": {},
+		},
+		ModeOverride: &ModeOverrideConfig{Allow: []string{"claude"}},
+	}
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := proxyHandler(upstreams)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5@claude"}`)))
+	if gotModel != "claude-sonnet-4-5" || rec.Header().Get(headerRRouterTarget) != "claude" {
+		t.Errorf("suffix override: upstream model %q, target %q", gotModel, rec.Header().Get(headerRRouterTarget))
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5"}`)))
+	if gotModel != "gemini-pro" || rec.Header().Get(headerRRouterTarget) != "gemini" {
+		t.Errorf("global mode: upstream model %q, target %q", gotModel, rec.Header().Get(headerRRouterTarget))
+	}
+	if gotHeader != "" {
+		t.Errorf("override header leaked upstream: %q", gotHeader)
+	}
+}
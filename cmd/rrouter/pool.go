@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pool selection strategies.
+const (
+	poolStrategyWeighted      = "weighted" // smooth weighted round-robin (default)
+	poolStrategyLeastInflight = "least_inflight"
+	poolStrategyRandom        = "random"
+)
+
+// PoolConfig groups several upstreams (e.g. CLIProxyAPI instances or OAuth
+// accounts) behind one name that modes can reference as their upstream.
+type PoolConfig struct {
+	Strategy string       `json:"strategy,omitempty"`
+	Members  []PoolMember `json:"members"`
+}
+
+type PoolMember struct {
+	Upstream string `json:"upstream"`         // name from Config.Upstreams or a literal URL
+	Weight   int    `json:"weight,omitempty"` // default 1
+}
+
+// poolMember is one upstream in a pool with its passive health state.
+// Health fields are guarded by upstreamPool.mu.
+type poolMember struct {
+	up       *upstream
+	weight   int
+	current  int // smooth weighted round-robin state
+	inflight int
+
+	failureCount int
+	timeoutCount int
+	ejectedUntil time.Time
+	ejections    int
+	cooldown     time.Duration
+	healthySince time.Time
+}
+
+// upstreamPool selects a member per attempt and passively ejects members
+// that fail, using the same thresholds and cooldown escalation as auto mode.
+// Plain upstreams are wrapped in a single-member pool, which never ejects.
+type upstreamPool struct {
+	name     string
+	strategy string
+
+	mu      sync.Mutex
+	members []*poolMember
+	now     func() time.Time
+	intn    func(n int) int
+}
+
+func newUpstreamPool(name, strategy string) *upstreamPool {
+	if strategy == "" {
+		strategy = poolStrategyWeighted
+	}
+	return &upstreamPool{
+		name:     name,
+		strategy: strategy,
+		now:      time.Now,
+		intn:     rand.IntN,
+	}
+}
+
+func validPoolStrategy(strategy string) bool {
+	switch strategy {
+	case "", poolStrategyWeighted, poolStrategyLeastInflight, poolStrategyRandom:
+		return true
+	}
+	return false
+}
+
+func (p *upstreamPool) addMember(up *upstream, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	p.members = append(p.members, &poolMember{up: up, weight: weight, cooldown: initialCooldown})
+}
+
+// acquire selects a member and counts it as in flight. Ejected members are
+// skipped unless every member is ejected, in which case all are eligible.
+func (p *upstreamPool) acquire() *poolMember {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.members) == 1 {
+		p.members[0].inflight++
+		return p.members[0]
+	}
+
+	now := p.now()
+	candidates := make([]*poolMember, 0, len(p.members))
+	for _, m := range p.members {
+		if !now.Before(m.ejectedUntil) {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		log.Printf("[POOL] %s: all members ejected, trying all", p.name)
+		candidates = p.members
+	}
+
+	var chosen *poolMember
+	switch p.strategy {
+	case poolStrategyLeastInflight:
+		for _, m := range candidates {
+			// Compare inflight/weight without division
+			if chosen == nil || m.inflight*chosen.weight < chosen.inflight*m.weight {
+				chosen = m
+			}
+		}
+	case poolStrategyRandom:
+		total := 0
+		for _, m := range candidates {
+			total += m.weight
+		}
+		n := p.intn(total)
+		for _, m := range candidates {
+			if n < m.weight {
+				chosen = m
+				break
+			}
+			n -= m.weight
+		}
+	default:
+		total := 0
+		for _, m := range candidates {
+			m.current += m.weight
+			total += m.weight
+			if chosen == nil || m.current > chosen.current {
+				chosen = m
+			}
+		}
+		chosen.current -= total
+	}
+
+	chosen.inflight++
+	return chosen
+}
+
+// release records an attempt's outcome: 2xx resets the member's counters,
+// timeouts and HTTP errors count toward ejection separately.
+func (p *upstreamPool) release(m *poolMember, statusCode int, isTimeout bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m.inflight--
+	if len(p.members) == 1 {
+		return
+	}
+
+	now := p.now()
+	if !isTimeout && statusCode >= 200 && statusCode < 300 {
+		m.failureCount = 0
+		m.timeoutCount = 0
+		if m.healthySince.IsZero() {
+			m.healthySince = now
+		}
+		if m.cooldown > initialCooldown && now.Sub(m.healthySince) >= m.cooldown*2 {
+			m.cooldown = initialCooldown
+		}
+		return
+	}
+
+	m.healthySince = time.Time{}
+	var reason string
+	switch {
+	case isTimeout:
+		m.timeoutCount++
+		m.failureCount = 0
+		if m.timeoutCount >= timeoutThreshold {
+			reason = "timeout"
+		}
+	case statusCode >= 400:
+		m.failureCount++
+		m.timeoutCount = 0
+		if m.failureCount >= failureThreshold {
+			reason = fmt.Sprintf("HTTP %d", statusCode)
+		}
+	}
+	if reason == "" {
+		return
+	}
+
+	if m.ejections > 0 {
+		m.cooldown = min(m.cooldown*2, maxCooldown)
+	}
+	m.ejections++
+	m.ejectedUntil = now.Add(m.cooldown)
+	m.failureCount = 0
+	m.timeoutCount = 0
+	log.Printf("[POOL] %s: ejecting %s for %s (%s)", p.name, m.up.name, m.cooldown, reason)
+}
+
+// serve forwards one attempt to a member and records its outcome.
+func (p *upstreamPool) serve(w statusResponseWriter, r *http.Request, result *proxyResult) {
+	m := p.acquire()
+	done := false
+	defer func() {
+		if !done {
+			// ServeHTTP panicked (http.ErrAbortHandler when the response
+			// copy fails mid-body): free the slot without judging the member
+			p.drop(m)
+		}
+	}()
+	m.up.proxy.ServeHTTP(w, r)
+	done = true
+
+	result.mu.Lock()
+	failed, isTimeout := result.err != nil, result.isTimeout
+	canceled := errors.Is(result.err, context.Canceled)
+	result.mu.Unlock()
+
+	if canceled {
+		// Client went away or a hedge lost: says nothing about the member
+		p.drop(m)
+		return
+	}
+
+	status := w.StatusCode()
+	if failed {
+		status = 0
+	} else if info, ok := r.Context().Value(routeInfoKey).(*routeInfo); ok && info.stream != nil {
+		if reason := info.stream.failure(); reason == streamTimeout {
+			status, isTimeout = 0, true
+		} else if reason != "" {
+			status = streamFailureStatus(reason)
+		}
+	}
+	p.release(m, status, isTimeout)
+}
+
+// drop ends an attempt on m without recording an outcome.
+func (p *upstreamPool) drop(m *poolMember) {
+	p.mu.Lock()
+	m.inflight--
+	p.mu.Unlock()
+}
+
+// peek returns a healthy member's upstream without counting an attempt, for
+// requests that shouldn't affect pool health (e.g. listing models).
+func (p *upstreamPool) peek() *upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for _, m := range p.members {
+		if !now.Before(m.ejectedUntil) {
+			return m.up
+		}
+	}
+	return p.members[0].up
+}
+
+// HealthInfo returns pool membership and health for /health.
+func (p *upstreamPool) HealthInfo() map[string]interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	members := make([]map[string]interface{}, 0, len(p.members))
+	for _, m := range p.members {
+		info := map[string]interface{}{
+			"upstream": m.up.name,
+			"url":      m.up.url,
+			"weight":   m.weight,
+			"inflight": m.inflight,
+			"healthy":  !now.Before(m.ejectedUntil),
+		}
+		if now.Before(m.ejectedUntil) {
+			info["ejectedRemaining"] = m.ejectedUntil.Sub(now).Round(time.Second).String()
+		}
+		if m.ejections > 0 {
+			info["ejections"] = m.ejections
+		}
+		members = append(members, info)
+	}
+	return map[string]interface{}{
+		"strategy": p.strategy,
+		"members":  members,
+	}
+}
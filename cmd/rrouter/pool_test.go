@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestPool(strategy string, weights ...int) *upstreamPool {
+	p := newUpstreamPool("test", strategy)
+	for i, w := range weights {
+		p.addMember(&upstream{name: string(rune('a' + i))}, w)
+	}
+	return p
+}
+
+// pickSequence acquires and immediately releases n members with status 200.
+func pickSequence(p *upstreamPool, n int) string {
+	var seq []byte
+	for i := 0; i < n; i++ {
+		m := p.acquire()
+		seq = append(seq, m.up.name...)
+		p.release(m, 200, false)
+	}
+	return string(seq)
+}
+
+func TestUpstreamPool_WeightedRoundRobin(t *testing.T) {
+	p := newTestPool(poolStrategyWeighted, 2, 1)
+	if got := pickSequence(p, 6); got != "abaaba" {
+		t.Errorf("sequence = %q, want %q", got, "abaaba")
+	}
+}
+
+func TestUpstreamPool_LeastInflight(t *testing.T) {
+	p := newTestPool(poolStrategyLeastInflight, 1, 1, 1)
+
+	first := p.acquire()
+	second := p.acquire()
+	third := p.acquire()
+	if first == second || second == third || first == third {
+		t.Fatalf("expected three distinct members, got %s %s %s", first.up.name, second.up.name, third.up.name)
+	}
+
+	p.release(second, 200, false)
+	if m := p.acquire(); m != second {
+		t.Errorf("acquire = %s, want the idle member %s", m.up.name, second.up.name)
+	}
+}
+
+func TestUpstreamPool_RandomRespectsWeights(t *testing.T) {
+	p := newTestPool(poolStrategyRandom, 1, 3)
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "a"},
+		{1, "b"},
+		{3, "b"},
+	}
+	for _, tt := range tests {
+		p.intn = func(int) int { return tt.n }
+		if m := p.acquire(); m.up.name != tt.want {
+			t.Errorf("intn=%d: acquire = %s, want %s", tt.n, m.up.name, tt.want)
+		}
+	}
+}
+
+func TestUpstreamPool_EjectsFailingMember(t *testing.T) {
+	now := time.Now()
+	p := newTestPool(poolStrategyWeighted, 1, 1)
+	p.now = func() time.Time { return now }
+	a := p.members[0]
+
+	for i := 0; i < failureThreshold; i++ {
+		a.inflight++
+		p.release(a, 503, false)
+	}
+	if !now.Before(a.ejectedUntil) {
+		t.Fatal("member should be ejected after failureThreshold errors")
+	}
+	if got := pickSequence(p, 3); got != "bbb" {
+		t.Errorf("sequence while ejected = %q, want only b", got)
+	}
+
+	// Cooldown expires: member is eligible again
+	now = now.Add(initialCooldown)
+	if got := pickSequence(p, 2); got != "ab" && got != "ba" {
+		t.Errorf("sequence after cooldown = %q, want both members", got)
+	}
+
+	// Second ejection escalates the cooldown
+	for i := 0; i < timeoutThreshold; i++ {
+		a.inflight++
+		p.release(a, 0, true)
+	}
+	if want := now.Add(initialCooldown * 2); !a.ejectedUntil.Equal(want) {
+		t.Errorf("ejectedUntil = %v, want %v", a.ejectedUntil, want)
+	}
+}
+
+func TestUpstreamPool_AllEjectedFailsOpen(t *testing.T) {
+	p := newTestPool(poolStrategyWeighted, 1, 1)
+	for _, m := range p.members {
+		m.ejectedUntil = time.Now().Add(time.Hour)
+	}
+	if got := pickSequence(p, 2); got != "ab" {
+		t.Errorf("sequence = %q, want all members tried", got)
+	}
+}
+
+func TestUpstreamPool_SingleMemberNeverEjects(t *testing.T) {
+	p := newTestPool("", 1)
+	m := p.members[0]
+	for i := 0; i < failureThreshold*2; i++ {
+		m.inflight++
+		p.release(m, 500, false)
+	}
+	if !m.ejectedUntil.IsZero() {
+		t.Error("single-member pool should not eject")
+	}
+}
+
+func TestUpstreamPool_AbortedResponseFreesSlot(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("truncated"))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler) // drop the connection mid-body
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+
+	p := newUpstreamPool("test", poolStrategyLeastInflight)
+	p.addMember(&upstream{name: "a", proxy: httputil.NewSingleHostReverseProxy(target)}, 1)
+	p.addMember(&upstream{name: "b", proxy: httputil.NewSingleHostReverseProxy(target)}, 1)
+
+	// ReverseProxy only panics on copy errors inside a real server
+	ctx := context.WithValue(context.Background(), http.ServerContextKey, &http.Server{})
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil).WithContext(ctx)
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Fatalf("recover() = %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		p.serve(newLoggingResponseWriter(httptest.NewRecorder()), req, &proxyResult{})
+	}()
+
+	for _, m := range p.members {
+		if m.inflight != 0 {
+			t.Errorf("%s inflight = %d after aborted response, want 0", m.up.name, m.inflight)
+		}
+	}
+}
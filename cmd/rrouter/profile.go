@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProfileConfig gives a group of clients (a project, a teammate) its own
+// mode file and auto-switch state on the shared daemon. Clients select a
+// profile with a path prefix (http://localhost:8316/p/<name>) or one of its
+// API keys; everything else uses ~/.rrouter/mode.
+type ProfileConfig struct {
+	APIKeys     []string `json:"apiKeys,omitempty"`
+	DefaultMode string   `json:"defaultMode,omitempty"` // default: Config.DefaultMode
+}
+
+const profilePathPrefix = "/p/"
+
+// profilesDir holds one directory per profile, each with its own "mode" file.
+const profilesDir = "profiles"
+
+// profile is the mode source and auto state requests are routed with.
+type profile struct {
+	name    string
+	watcher *ConfigWatcher
+	auto    *autoState
+}
+
+func (p *profile) mode() string {
+	return p.watcher.GetMode()
+}
+
+// profileSet resolves requests to profiles. Built once at startup.
+type profileSet struct {
+	byName map[string]*profile
+	byKey  map[string]*profile
+}
+
+var serveProfiles *profileSet
+
+// newProfileSet starts a mode watcher for every configured profile under
+// dir/profiles/<name>.
+func newProfileSet(cfg *Config, dir string) *profileSet {
+	set := &profileSet{
+		byName: make(map[string]*profile),
+		byKey:  make(map[string]*profile),
+	}
+	for _, name := range sortedKeys(cfg.Profiles) {
+		pc := cfg.Profiles[name]
+		if pc == nil {
+			pc = &ProfileConfig{}
+		}
+		defaultMode := pc.DefaultMode
+		if defaultMode == "" {
+			defaultMode = cfg.DefaultMode
+		}
+		profileDir := filepath.Join(dir, profilesDir, name)
+		if err := os.MkdirAll(profileDir, 0755); err != nil {
+			log.Printf("[PROFILE] Cannot create %s: %v", profileDir, err)
+		}
+		// The auto state needs a concrete target to start from; a profile
+		// that defaults to auto starts where the daemon's does
+		autoTarget := defaultMode
+		if autoTarget == "auto" {
+			autoTarget = cfg.DefaultMode
+		}
+		if autoTarget == "auto" {
+			autoTarget = ""
+		}
+		auto := newAutoState(autoTarget)
+		p := &profile{
+			name: name,
+			auto: auto,
+			watcher: startConfigWatcher(&ConfigWatcher{
+				dir:         profileDir,
+				config:      cfg,
+				profile:     name,
+				defaultMode: defaultMode,
+				auto:        auto,
+			}),
+		}
+		set.byName[name] = p
+		for _, key := range pc.APIKeys {
+			if other, ok := set.byKey[key]; ok {
+				log.Printf("[WARN] Profile %q: API key already used by profile %q", name, other.name)
+				continue
+			}
+			set.byKey[key] = p
+		}
+	}
+	return set
+}
+
+func validateProfiles(cfg *Config) {
+	for name, pc := range cfg.Profiles {
+		if name == "" || strings.Contains(name, "/") {
+			log.Printf("[WARN] Profile %q: name must be non-empty and contain no '/'", name)
+		}
+		if pc == nil || pc.DefaultMode == "" {
+			continue
+		}
+		if _, ok := cfg.Modes[pc.DefaultMode]; !ok && pc.DefaultMode != "auto" {
+			log.Printf("[WARN] Profile %q: unknown defaultMode %q", name, pc.DefaultMode)
+		}
+	}
+}
+
+// Close stops every profile watcher.
+func (s *profileSet) Close() {
+	for _, p := range s.byName {
+		p.watcher.Close()
+	}
+}
+
+// wrap selects the profile for each request and passes it to next in the
+// request context. A /p/<name> prefix is stripped so the inner handlers see
+// the plain API path; an unknown profile name is a 404.
+func (s *profileSet) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rest, ok := strings.CutPrefix(r.URL.Path, profilePathPrefix); ok {
+			name, path, _ := strings.Cut(rest, "/")
+			p, ok := s.byName[name]
+			if !ok {
+				writeAnthropicError(w, http.StatusNotFound, "not_found_error", "unknown rrouter profile "+name)
+				return
+			}
+			r = r.Clone(context.WithValue(r.Context(), profileKey, p))
+			r.URL.Path = "/" + path
+			r.URL.RawPath = ""
+			next.ServeHTTP(w, r)
+			return
+		}
+		if p, ok := s.byKey[requestAPIKey(r)]; ok {
+			r = r.WithContext(context.WithValue(r.Context(), profileKey, p))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIKey returns the client's x-api-key or bearer token.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// profileFor returns the profile selected for r, or the default profile
+// (~/.rrouter/mode and the global auto state).
+func profileFor(r *http.Request) *profile {
+	if p, ok := r.Context().Value(profileKey).(*profile); ok {
+		return p
+	}
+	return &profile{name: "default", watcher: configWatcher, auto: autoSwitch}
+}
+
+// HealthInfo returns each profile's mode and target for /health.
+func (s *profileSet) HealthInfo() map[string]interface{} {
+	info := make(map[string]interface{}, len(s.byName))
+	for name, p := range s.byName {
+		mode := p.mode()
+		info[name] = map[string]interface{}{
+			"mode":          mode,
+			"currentTarget": p.auto.resolveRouting(mode),
+		}
+	}
+	return info
+}
+
+// extractProfileFlag removes "--profile <name>" (or "--profile=<name>") from
+// args and points the CLI's mode file and health URL at that profile.
+func extractProfileFlag(args []string) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--profile" && i+1 < len(args):
+			cliProfile = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--profile="):
+			cliProfile = strings.TrimPrefix(args[i], "--profile=")
+		default:
+			out = append(out, args[i])
+		}
+	}
+	if cliProfile != "" {
+		modeFile = filepath.Join(rrouterDir, profilesDir, cliProfile, "mode")
+	}
+	return out
+}
+
+// healthURL is the daemon's /health endpoint for the CLI's profile.
+func healthURL() string {
+	if cliProfile != "" {
+		return "http://localhost:8316" + profilePathPrefix + cliProfile + "/health"
+	}
+	return "http://localhost:8316/health"
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestProfile returns a profile whose mode comes from a mode file in a
+// temp dir (defaulting to defaultMode), without a filesystem watcher.
+func newTestProfile(t *testing.T, name, defaultMode string) *profile {
+	t.Helper()
+	auto := newAutoState(defaultMode)
+	return &profile{
+		name:    name,
+		auto:    auto,
+		watcher: &ConfigWatcher{dir: t.TempDir(), defaultMode: defaultMode, auto: auto},
+	}
+}
+
+func TestProfileSet_Routing(t *testing.T) {
+	var gotPath, gotModel string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &req)
+		gotPath, gotModel = r.URL.Path, req.Model
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		DefaultMode: "gemini",
+		Modes: map[string]ModeConfig{
+			"gemini": {Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-pro"}}},
+			"claude": {},
+		},
+	}
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	work := newTestProfile(t, "work", "claude")
+	set := &profileSet{
+		byName: map[string]*profile{"work": work},
+		byKey:  map[string]*profile{"sk-work": work},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", proxyHandler(upstreams))
+	handler := set.wrap(mux)
+
+	tests := []struct {
+		name       string
+		path       string
+		apiKey     string
+		wantStatus int
+		wantPath   string
+		wantModel  string
+	}{
+		{name: "default profile", path: "/v1/messages", wantStatus: http.StatusOK, wantPath: "/v1/messages", wantModel: "gemini-pro"},
+		{name: "path prefix", path: "/p/work/v1/messages", wantStatus: http.StatusOK, wantPath: "/v1/messages", wantModel: "claude-sonnet-4"},
+		{name: "api key", path: "/v1/messages", apiKey: "sk-work", wantStatus: http.StatusOK, wantPath: "/v1/messages", wantModel: "claude-sonnet-4"},
+		{name: "unknown profile", path: "/p/nope/v1/messages", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotModel = "", ""
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"model":"claude-sonnet-4"}`))
+			if tt.apiKey != "" {
+				req.Header.Set("X-Api-Key", tt.apiKey)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotPath != tt.wantPath || gotModel != tt.wantModel {
+				t.Errorf("upstream saw %s %q, want %s %q", gotPath, gotModel, tt.wantPath, tt.wantModel)
+			}
+		})
+	}
+
+	// The profile's own mode file takes effect without touching the default
+	if err := os.WriteFile(filepath.Join(work.watcher.dir, "mode"), []byte("gemini"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/p/work/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4"}`)))
+	if gotModel != "gemini-pro" {
+		t.Errorf("after mode file change, upstream model = %q, want gemini-pro", gotModel)
+	}
+}
+
+func TestNewProfileSet_AutoDefaultMode(t *testing.T) {
+	tests := []struct {
+		globalDefault string
+		want          string
+	}{
+		{"claude", "claude"},
+		{"gemini", "gemini"},
+		{"auto", "antigravity"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{
+			DefaultMode: tt.globalDefault,
+			Profiles:    map[string]*ProfileConfig{"work": {DefaultMode: "auto"}},
+		}
+		set := newProfileSet(cfg, t.TempDir())
+		p := set.byName["work"]
+		if got := p.auto.resolveRouting(p.mode()); got != tt.want {
+			t.Errorf("global default %q: auto profile routes to %q, want %q", tt.globalDefault, got, tt.want)
+		}
+		set.Close()
+	}
+}
+
+func TestExtractProfileFlag(t *testing.T) {
+	oldProfile, oldModeFile := cliProfile, modeFile
+	t.Cleanup(func() { cliProfile, modeFile = oldProfile, oldModeFile })
+
+	tests := []struct {
+		args        []string
+		wantArgs    []string
+		wantProfile string
+	}{
+		{[]string{"rrouter", "status"}, []string{"rrouter", "status"}, ""},
+		{[]string{"rrouter", "claude", "--profile", "work"}, []string{"rrouter", "claude"}, "work"},
+		{[]string{"rrouter", "--profile=home", "auto"}, []string{"rrouter", "auto"}, "home"},
+	}
+	for _, tt := range tests {
+		cliProfile, modeFile = "", oldModeFile
+		got := extractProfileFlag(tt.args)
+		if !reflect.DeepEqual(got, tt.wantArgs) || cliProfile != tt.wantProfile {
+			t.Errorf("extractProfileFlag(%v) = %v, profile %q", tt.args, got, cliProfile)
+		}
+		if tt.wantProfile != "" {
+			if want := filepath.Join(rrouterDir, profilesDir, tt.wantProfile, "mode"); modeFile != want {
+				t.Errorf("modeFile = %q, want %q", modeFile, want)
+			}
+			if !strings.HasSuffix(healthURL(), "/p/"+tt.wantProfile+"/health") {
+				t.Errorf("healthURL = %q", healthURL())
+			}
+		}
+	}
+}
@@ -4,7 +4,9 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"log"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,16 +18,90 @@ var defaultConfigJSON []byte
 type Config struct {
 	Modes       map[string]ModeConfig `json:"modes"`
 	DefaultMode string                `json:"defaultMode"`
+	Sessions    *SessionConfig        `json:"sessions,omitempty"` // auto-mode session pinning
+
+	// Upstreams are named backend endpoints that modes can route to
+	// directly (see ModeConfig.Upstream). RROUTER_UPSTREAM is "default".
+	Upstreams map[string]*UpstreamConfig `json:"upstreams,omitempty"`
+	// Pools load-balance across several upstreams; modes reference them by
+	// name like an upstream.
+	Pools map[string]*PoolConfig `json:"pools,omitempty"`
+
+	// RetryStreamErrors lets auto mode retry a 2xx stream that fails (error
+	// event or dropped connection) before any content reached the client.
+	// Streams are held back until their first content delta.
+	RetryStreamErrors bool `json:"retryStreamErrors,omitempty"`
+
+	// Routes decide per path whether requests are rewritten, passed
+	// through unrewritten or blocked. Unlisted paths are rewritten.
+	Routes []RouteRule `json:"routes,omitempty"`
+
+	// ModeOverride lets individual requests select an allowed mode.
+	ModeOverride *ModeOverrideConfig `json:"modeOverride,omitempty"`
+
+	// Profiles give groups of clients their own mode file and auto state.
+	Profiles map[string]*ProfileConfig `json:"profiles,omitempty"`
+
+	// Models controls the synthesized GET /v1/models listing.
+	Models *ModelsConfig `json:"models,omitempty"`
+
+	// Schedule switches the default mode by time of day.
+	Schedule *ScheduleConfig `json:"schedule,omitempty"`
 }
 
 type ModeConfig struct {
-	Mappings     []ModelMapping      `json:"mappings"`
-	AgentRouting *AgentRoutingConfig `json:"agentRouting,omitempty"`
+	Mappings       []ModelMapping        `json:"mappings"`
+	AgentRouting   *AgentRoutingConfig   `json:"agentRouting,omitempty"`
+	ContextRouting *ContextRoutingConfig `json:"contextRouting,omitempty"`
+	Transform      *ParamTransform       `json:"transform,omitempty"` // applied whenever the model is rewritten
+	Sanitize       *SanitizeConfig       `json:"sanitize,omitempty"`
+
+	// Shadow mirrors a sample of this mode's requests to another mode.
+	Shadow *ShadowConfig `json:"shadow,omitempty"`
+
+	// Headers edit request headers forwarded for this mode, after the
+	// upstream's own headers.
+	Headers []HeaderRule `json:"headers,omitempty"`
+
+	// Upstream is a name from Config.Pools or Config.Upstreams, or a
+	// literal URL. Empty means RROUTER_UPSTREAM.
+	Upstream string `json:"upstream,omitempty"`
+
+	// Backend is the model family behind this mode ("anthropic" or "gemini"),
+	// used to decide which thinking blocks are valid. See backendFor.
+	Backend string `json:"backend,omitempty"`
+	// UnsignedThinking is "text" (default) or "drop".
+	UnsignedThinking string `json:"unsignedThinking,omitempty"`
+
+	// RestoreResponseModel reports the client's requested model name in
+	// responses instead of the rewritten backend model.
+	RestoreResponseModel bool `json:"restoreResponseModel,omitempty"`
+
+	// CountTokens is how /v1/messages/count_tokens is handled: "rewrite"
+	// (default), "forward" or "local". See count_tokens.go.
+	CountTokens string `json:"countTokens,omitempty"`
 }
 
 type ModelMapping struct {
-	Match   string `json:"match"`
+	Match     string          `json:"match"`
+	Rewrite   string          `json:"rewrite"`
+	Transform *ParamTransform `json:"transform,omitempty"` // applied when this mapping's rewrite is used
+
+	// Targets splits traffic between several rewrites by weight (A/B
+	// testing). When set, it takes precedence over Rewrite.
+	Targets []RewriteTarget `json:"targets,omitempty"`
+	// Sticky keeps a session on the same target (see SessionConfig for
+	// how sessions are identified).
+	Sticky bool `json:"sticky,omitempty"`
+
+	// Hedge duplicates slow requests to a fallback mode (latency-sensitive
+	// models such as haiku).
+	Hedge *HedgeConfig `json:"hedge,omitempty"`
+}
+
+type RewriteTarget struct {
 	Rewrite string `json:"rewrite"`
+	Weight  int    `json:"weight"`
 }
 
 func loadConfig(path string) (*Config, error) {
@@ -84,18 +160,84 @@ func matchModel(pattern, model string) bool {
 	return matched
 }
 
-func rewriteModelWithConfig(model string, modeConfig *ModeConfig) string {
+// findMapping returns the first mapping whose pattern matches model, or nil.
+func findMapping(model string, modeConfig *ModeConfig) *ModelMapping {
 	if modeConfig == nil {
-		return model
+		return nil
 	}
-	for _, m := range modeConfig.Mappings {
-		if matchModel(m.Match, model) {
-			return m.Rewrite
+	for i := range modeConfig.Mappings {
+		if matchModel(modeConfig.Mappings[i].Match, model) {
+			return &modeConfig.Mappings[i]
 		}
 	}
+	return nil
+}
+
+func rewriteModelWithConfig(model string, modeConfig *ModeConfig) string {
+	if m := findMapping(model, modeConfig); m != nil {
+		return m.Rewrite
+	}
 	return model // passthrough if no match
 }
 
+// totalWeight is the sum of the positive target weights.
+func (m *ModelMapping) totalWeight() int {
+	total := 0
+	for _, t := range m.Targets {
+		total += max(t.Weight, 0)
+	}
+	return total
+}
+
+// fallback is the model used when no weighted target can be picked:
+// Rewrite, or the client's model if that is empty too.
+func (m *ModelMapping) fallback(model string) string {
+	if m.Rewrite != "" {
+		return m.Rewrite
+	}
+	return model
+}
+
+// pickTarget chooses one of the mapping's weighted targets for model. With
+// Sticky and a non-empty session key the choice is a stable hash of the key,
+// so a session always lands on the same target; otherwise it is random.
+func (m *ModelMapping) pickTarget(model, sessionKey string) string {
+	total := m.totalWeight()
+	if total == 0 {
+		return m.fallback(model)
+	}
+
+	var n int
+	if m.Sticky && sessionKey != "" {
+		h := fnv.New32a()
+		h.Write([]byte(m.Match + "\x00" + sessionKey))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = rand.IntN(total)
+	}
+	for _, t := range m.Targets {
+		if n < max(t.Weight, 0) {
+			return t.Rewrite
+		}
+		n -= max(t.Weight, 0)
+	}
+	return m.fallback(model)
+}
+
+// validateMappings warns about A/B targets that can never be picked.
+func validateMappings(mappings []ModelMapping, modeName string) {
+	for _, m := range mappings {
+		if len(m.Targets) == 0 || m.totalWeight() > 0 {
+			continue
+		}
+		if m.Rewrite != "" {
+			log.Printf("[WARN] Mode '%s': mapping '%s' targets have a total weight of 0; using rewrite '%s'", modeName, m.Match, m.Rewrite)
+		} else {
+			log.Printf("[WARN] Mode '%s': mapping '%s' targets have a total weight of 0 and no rewrite; the client's model is kept", modeName, m.Match)
+		}
+	}
+}
+
 func getConfig() (listenAddr string, upstreamURL string) {
 	port := os.Getenv("RROUTER_PORT")
 	if port == "" {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -350,3 +351,84 @@ func TestModifyRequestBody(t *testing.T) {
 		})
 	}
 }
+
+func TestModelMapping_PickTarget(t *testing.T) {
+	m := &ModelMapping{
+		Match:   "claude-opus-*",
+		Rewrite: "gemini-3-pro-preview",
+		Targets: []RewriteTarget{
+			{Rewrite: "gemini-3-pro-preview", Weight: 80},
+			{Rewrite: "gemini-3-pro-preview-thinking", Weight: 20},
+		},
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		counts[m.pickTarget("claude-opus-4", "")]++
+	}
+	if counts["gemini-3-pro-preview"] < 1400 || counts["gemini-3-pro-preview-thinking"] < 200 {
+		t.Errorf("split = %v, want roughly 80/20", counts)
+	}
+
+	m.Sticky = true
+	for _, key := range []string{"header:a", "header:b", "metadata:c"} {
+		first := m.pickTarget("claude-opus-4", key)
+		for i := 0; i < 20; i++ {
+			if got := m.pickTarget("claude-opus-4", key); got != first {
+				t.Fatalf("sticky key %q moved from %s to %s", key, first, got)
+			}
+		}
+	}
+
+	zero := &ModelMapping{Rewrite: "fallback", Targets: []RewriteTarget{{Rewrite: "x", Weight: 0}}}
+	if got := zero.pickTarget("claude-opus-4", ""); got != "fallback" {
+		t.Errorf("zero total weight = %q, want Rewrite", got)
+	}
+	zero.Rewrite = ""
+	if got := zero.pickTarget("claude-opus-4", ""); got != "claude-opus-4" {
+		t.Errorf("zero total weight without Rewrite = %q, want the client's model", got)
+	}
+}
+
+func TestRewriteRequest_ZeroWeightTargetsKeepModel(t *testing.T) {
+	mc := &ModeConfig{Mappings: []ModelMapping{{
+		Match:   "claude-opus-*",
+		Targets: []RewriteTarget{{Rewrite: "gemini-3-pro-preview", Weight: 0}},
+	}}}
+	res, err := rewriteRequest([]byte(`{"model":"claude-opus-4"}`), mc, "antigravity", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.model != "claude-opus-4" || res.variant != "" {
+		t.Errorf("model = %q, variant = %q; want the client's model and no split", res.model, res.variant)
+	}
+}
+
+func TestRewriteRequest_RecordsVariant(t *testing.T) {
+	mc := &ModeConfig{
+		Mappings: []ModelMapping{{
+			Match:   "claude-opus-*",
+			Targets: []RewriteTarget{{Rewrite: "gemini-3-pro-preview-thinking", Weight: 1}},
+		}},
+		ContextRouting: &ContextRoutingConfig{
+			Rules: []ContextRule{{Above: 100, Rewrite: "gemini-long-context"}},
+		},
+	}
+
+	res, err := rewriteRequest([]byte(`{"model":"claude-opus-4-5","messages":[{"role":"user","content":"hi"}]}`), mc, "antigravity", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.model != "gemini-3-pro-preview-thinking" || res.variant != res.model || res.experiment != "claude-opus-*" {
+		t.Errorf("model=%q variant=%q experiment=%q", res.model, res.variant, res.experiment)
+	}
+
+	long := `{"model":"claude-opus-4-5","messages":[{"role":"user","content":"` + strings.Repeat("word ", 500) + `"}]}`
+	res, err = rewriteRequest([]byte(long), mc, "antigravity", "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.model != "gemini-long-context" || res.variant != "" {
+		t.Errorf("overridden split: model=%q variant=%q, want no variant", res.model, res.variant)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// sseHook inspects one SSE "data:" payload (with its event name) and returns
+// the payload to forward, which may be the original slice.
+type sseHook func(event string, data []byte) []byte
+
+// sseRewriter passes an SSE stream through line by line, running hooks over
+// each data line. Only an incomplete trailing line is held back, so events
+// are forwarded as soon as upstream finishes writing them.
+type sseRewriter struct {
+	src     io.ReadCloser
+	hooks   []sseHook
+	onEnd   sseEndHook // optional, see sseEndHook
+	event   string     // name from the most recent "event:" line
+	pending []byte     // incomplete line read from src
+	out     []byte     // processed bytes not yet returned to the caller
+	err     error
+	buf     []byte
+}
+
+func newSSERewriter(src io.ReadCloser, hooks ...sseHook) *sseRewriter {
+	return &sseRewriter{src: src, hooks: hooks, buf: make([]byte, 32*1024)}
+}
+
+func (s *sseRewriter) Read(p []byte) (int, error) {
+	for len(s.out) == 0 && s.err == nil {
+		n, err := s.src.Read(s.buf)
+		if n > 0 {
+			s.pending = append(s.pending, s.buf[:n]...)
+			s.processLines()
+		}
+		if err != nil {
+			// Forward any unterminated tail as-is
+			s.out = append(s.out, s.pending...)
+			s.pending = nil
+			s.finish(err)
+		}
+	}
+	if len(s.out) > 0 {
+		n := copy(p, s.out)
+		s.out = s.out[n:]
+		return n, nil
+	}
+	return 0, s.err
+}
+
+func (s *sseRewriter) processLines() {
+	for {
+		idx := bytes.IndexByte(s.pending, '\n')
+		if idx < 0 {
+			return
+		}
+		line := s.pending[:idx+1]
+		s.pending = s.pending[idx+1:]
+		s.out = append(s.out, s.processLine(line)...)
+	}
+}
+
+func (s *sseRewriter) processLine(line []byte) []byte {
+	content := bytes.TrimRight(line, "\r\n")
+	switch {
+	case len(content) == 0:
+		s.event = ""
+	case bytes.HasPrefix(content, []byte("event:")):
+		s.event = strings.TrimSpace(string(content[len("event:"):]))
+	case bytes.HasPrefix(content, []byte("data:")):
+		data := bytes.TrimPrefix(content[len("data:"):], []byte(" "))
+		orig := data
+		for _, hook := range s.hooks {
+			data = hook(s.event, data)
+		}
+		if !bytes.Equal(orig, data) {
+			rebuilt := make([]byte, 0, len(data)+8)
+			rebuilt = append(rebuilt, "data: "...)
+			rebuilt = append(rebuilt, data...)
+			return append(rebuilt, line[len(content):]...)
+		}
+	}
+	return line
+}
+
+func (s *sseRewriter) Close() error {
+	return s.src.Close()
+}
+
+// isEventStream reports whether resp is an SSE stream.
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// isRewritableBody reports whether resp carries an uncompressed body.
+func isRewritableBody(resp *http.Response) bool {
+	enc := resp.Header.Get("Content-Encoding")
+	return enc == "" || enc == "identity"
+}
+
+// jsonHook inspects a buffered (non-streaming) JSON response and reports
+// whether it modified it.
+type jsonHook func(msg map[string]interface{}) bool
+
+// responseTap is the set of hooks run over one upstream response body.
+type responseTap struct {
+	sse    []sseHook
+	sseEnd sseEndHook
+	json   []jsonHook
+}
+
+// buildResponseTap assembles the hooks that apply to a response for the
+// attempt described by info.
+func buildResponseTap(info *routeInfo, statusCode int) *responseTap {
+	tap := &responseTap{}
+	if info.restoreModel && info.originalModel != "" && info.model != info.originalModel {
+		tap.sse = append(tap.sse, restoreModelSSEHook(info.originalModel))
+		tap.json = append(tap.json, restoreModelJSONHook(info.originalModel))
+	}
+	if statusCode >= 200 && statusCode < 300 && info.backend != "" {
+		tap.sse = append(tap.sse, signatureSSEHook(info.backend))
+		tap.json = append(tap.json, signatureJSONHook(info.backend))
+	}
+	if statusCode >= 200 && statusCode < 300 && info.stream != nil {
+		tap.sse = append(tap.sse, info.stream.hook)
+		tap.sseEnd = info.stream.end
+	}
+	if statusCode >= 200 && statusCode < 300 && info.usage != nil {
+		tap.sse = append(tap.sse, usageSSEHook(info.usage))
+		tap.json = append(tap.json, usageJSONHook(info.usage))
+	}
+	return tap
+}
+
+// apply wraps SSE bodies with a streaming rewriter and rewrites buffered
+// JSON bodies in place. Compressed and other bodies are left untouched.
+func (t *responseTap) apply(resp *http.Response) error {
+	if !isRewritableBody(resp) {
+		return nil
+	}
+
+	if isEventStream(resp) {
+		if len(t.sse) > 0 || t.sseEnd != nil {
+			rw := newSSERewriter(resp.Body, t.sse...)
+			rw.onEnd = t.sseEnd
+			resp.Body = rw
+		}
+		return nil
+	}
+
+	if len(t.json) == 0 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	var msg map[string]interface{}
+	if json.Unmarshal(body, &msg) == nil {
+		modified := false
+		for _, hook := range t.json {
+			if hook(msg) {
+				modified = true
+			}
+		}
+		if modified {
+			if out, err := json.Marshal(msg); err == nil {
+				body = out
+			}
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+// restoreModelSSEHook rewrites the model in the message_start event back to
+// the client's requested name.
+func restoreModelSSEHook(originalModel string) sseHook {
+	return func(event string, data []byte) []byte {
+		if event != "message_start" && !bytes.Contains(data, []byte(`"message_start"`)) {
+			return data
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return data
+		}
+		inner, ok := msg["message"].(map[string]interface{})
+		if !ok {
+			return data
+		}
+		if _, ok := inner["model"]; !ok {
+			return data
+		}
+		inner["model"] = originalModel
+		out, err := json.Marshal(msg)
+		if err != nil {
+			return data
+		}
+		return out
+	}
+}
+
+// restoreModelJSONHook rewrites the "model" field of a buffered response.
+func restoreModelJSONHook(originalModel string) jsonHook {
+	return func(msg map[string]interface{}) bool {
+		if _, ok := msg["model"].(string); !ok {
+			return false
+		}
+		msg["model"] = originalModel
+		return true
+	}
+}
+
+// tokenUsage is the token usage reported by upstream for one response.
+type tokenUsage struct {
+	input  int64
+	output int64
+}
+
+type usageFields struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// usageSSEHook captures usage from message_start (input) and message_delta
+// (cumulative output) events.
+func usageSSEHook(u *tokenUsage) sseHook {
+	return func(event string, data []byte) []byte {
+		if !bytes.Contains(data, []byte(`"usage"`)) {
+			return data
+		}
+		var ev struct {
+			Message struct {
+				Usage usageFields `json:"usage"`
+			} `json:"message"`
+			Usage usageFields `json:"usage"`
+		}
+		if json.Unmarshal(data, &ev) != nil {
+			return data
+		}
+		for _, f := range []usageFields{ev.Message.Usage, ev.Usage} {
+			if f.InputTokens > 0 {
+				u.input = f.InputTokens
+			}
+			if f.OutputTokens > 0 {
+				u.output = f.OutputTokens
+			}
+		}
+		return data
+	}
+}
+
+// usageJSONHook captures usage from a buffered response.
+func usageJSONHook(u *tokenUsage) jsonHook {
+	return func(msg map[string]interface{}) bool {
+		usage, _ := msg["usage"].(map[string]interface{})
+		if n, ok := usage["input_tokens"].(float64); ok {
+			u.input = int64(n)
+		}
+		if n, ok := usage["output_tokens"].(float64); ok {
+			u.output = int64(n)
+		}
+		return false
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// chunkedReader returns its input a few bytes at a time to exercise
+// line reassembly across reads.
+type chunkedReader struct {
+	data  string
+	chunk int
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.chunk
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	n = copy(p, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *chunkedReader) Close() error { return nil }
+
+func newTestResponse(contentType, body string) *http.Response {
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       &chunkedReader{data: body, chunk: 7},
+	}
+}
+
+func TestSSERewriter_PassesThroughUnchanged(t *testing.T) {
+	stream := "event: ping\ndata: {\"type\": \"ping\"}\n\nevent: message_stop\r\ndata: {}\r\n\r\npartial"
+	r := newSSERewriter(&chunkedReader{data: stream, chunk: 5}, func(event string, data []byte) []byte {
+		return data
+	})
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out) != stream {
+		t.Errorf("stream changed:\n got %q\nwant %q", out, stream)
+	}
+}
+
+func TestSSERewriter_HookSeesEventNames(t *testing.T) {
+	stream := "event: a\ndata: 1\n\nevent: b\ndata: 2\n\ndata: 3\n\n"
+	var seen []string
+	r := newSSERewriter(&chunkedReader{data: stream, chunk: 3}, func(event string, data []byte) []byte {
+		seen = append(seen, event+"="+string(data))
+		return data
+	})
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"a=1", "b=2", "=3"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("hook saw %v, want %v", seen, want)
+	}
+}
+
+func TestResponseTap_RestoreModelSSE(t *testing.T) {
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"gemini-claude-sonnet-4-5-thinking"}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","delta":{"text":"model gemini"}}` + "\n\n"
+	resp := newTestResponse("text/event-stream", stream)
+
+	info := &routeInfo{restoreModel: true, originalModel: "claude-sonnet-4-5", model: "gemini-claude-sonnet-4-5-thinking"}
+	if err := buildResponseTap(info, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	got := string(out)
+
+	if !strings.Contains(got, `"model":"claude-sonnet-4-5"`) {
+		t.Errorf("message_start model not restored: %s", got)
+	}
+	if strings.Contains(got, "gemini-claude-sonnet-4-5-thinking") {
+		t.Errorf("backend model leaked: %s", got)
+	}
+	if !strings.Contains(got, `"text":"model gemini"`) {
+		t.Errorf("content deltas must pass through unchanged: %s", got)
+	}
+}
+
+func TestResponseTap_RestoreModelJSON(t *testing.T) {
+	resp := newTestResponse("application/json", `{"id":"msg_1","model":"gemini-3-flash-preview","content":[]}`)
+
+	info := &routeInfo{restoreModel: true, originalModel: "claude-haiku-4-5", model: "gemini-3-flash-preview"}
+	if err := buildResponseTap(info, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(out), `"model":"claude-haiku-4-5"`) {
+		t.Errorf("model not restored: %s", out)
+	}
+	if resp.ContentLength != int64(len(out)) {
+		t.Errorf("ContentLength = %d, want %d", resp.ContentLength, len(out))
+	}
+}
+
+func TestResponseTap_RestoreModelSkipsCompressedBodies(t *testing.T) {
+	resp := newTestResponse("application/json", `{"model":"gemini"}`)
+	resp.Header.Set("Content-Encoding", "gzip")
+
+	info := &routeInfo{restoreModel: true, originalModel: "claude", model: "gemini"}
+	if err := buildResponseTap(info, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, _ := io.ReadAll(resp.Body)
+	if string(out) != `{"model":"gemini"}` {
+		t.Errorf("compressed body should be untouched, got %s", out)
+	}
+}
+
+func TestResponseTap_CapturesUsage(t *testing.T) {
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"model":"m","usage":{"input_tokens":100,"output_tokens":1}}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":42}}` + "\n\n"
+
+	info := &routeInfo{usage: &tokenUsage{}}
+	resp := newTestResponse("text/event-stream", stream)
+	if err := buildResponseTap(info, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	io.ReadAll(resp.Body)
+	if info.usage.input != 100 || info.usage.output != 42 {
+		t.Errorf("streamed usage = %+v, want 100/42", *info.usage)
+	}
+
+	info = &routeInfo{usage: &tokenUsage{}}
+	resp = newTestResponse("application/json", `{"usage":{"input_tokens":7,"output_tokens":9}}`)
+	if err := buildResponseTap(info, 200).apply(resp); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.usage.input != 7 || info.usage.output != 9 {
+		t.Errorf("buffered usage = %+v, want 7/9", *info.usage)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Route actions.
+const (
+	routeRewrite     = "rewrite"     // full pipeline: body rewriting, auto retry, stats
+	routePassthrough = "passthrough" // forwarded unrewritten to the mode's upstream
+	routeBlock       = "block"       // rejected with 404
+)
+
+// RouteRule decides how requests to a path are handled. Path is exact, or a
+// prefix when it ends in "*" ("/v1/files*" matches "/v1/files/abc").
+type RouteRule struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	// CountsForHealth makes rewritten requests count toward auto-mode and
+	// pool health and go through auto retry. Default: true, except for
+	// count_tokens; a count_tokens rule that sets it true takes the normal
+	// rewrite path, so the mode's countTokens setting doesn't apply.
+	CountsForHealth *bool `json:"countsForHealth,omitempty"`
+}
+
+// matches reports whether the rule applies to path (trailing slashes ignored).
+func (rr *RouteRule) matches(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	if prefix, ok := strings.CutSuffix(rr.Path, "*"); ok {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == strings.TrimSuffix(rr.Path, "/")
+}
+
+// countsForHealth reports whether requests on path affect auto/pool health.
+func (rr *RouteRule) countsForHealth(path string) bool {
+	if rr.CountsForHealth != nil {
+		return *rr.CountsForHealth
+	}
+	return !isAuxiliaryPath(path)
+}
+
+// matchRoute returns the first configured rule matching path, or a rewrite
+// rule: paths no rule lists are rewritten, as before routes existed.
+func matchRoute(routes []RouteRule, path string) *RouteRule {
+	for i := range routes {
+		if routes[i].matches(path) {
+			return &routes[i]
+		}
+	}
+	return &RouteRule{Path: path, Action: routeRewrite}
+}
+
+func validateRoutes(routes []RouteRule) {
+	for i, rr := range routes {
+		switch rr.Action {
+		case routeRewrite, routePassthrough, routeBlock:
+		default:
+			log.Printf("[WARN] routes[%d]: unknown action %q (want %s, %s or %s)", i, rr.Action, routeRewrite, routePassthrough, routeBlock)
+		}
+		if rr.Path == "" {
+			log.Printf("[WARN] routes[%d]: empty path never matches", i)
+		}
+	}
+}
+
+// serveDirect forwards one attempt to target's upstream without auto retry
+// or health accounting. Header rules of target apply either way.
+func serveDirect(upstreams *upstreamSet, w http.ResponseWriter, r *http.Request, target string, reqNum uint64, label string) {
+	r = r.WithContext(context.WithValue(r.Context(), targetKey, target))
+	lrw := newLoggingResponseWriter(w)
+	startTime := time.Now()
+	upstreams.forMode(target).peek().proxy.ServeHTTP(lrw, r)
+	log.Printf("[Req #%d] Response: %d (%s, %s)", reqNum, lrw.statusCode, formatDuration(time.Since(startTime)), label)
+}
+
+// withBody returns a copy of r carrying body.
+func withBody(r *http.Request, body []byte) *http.Request {
+	attempt := r.Clone(r.Context())
+	attempt.Body = io.NopCloser(bytes.NewReader(body))
+	attempt.ContentLength = int64(len(body))
+	return attempt
+}
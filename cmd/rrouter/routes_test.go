@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withProxyGlobals installs cfg and a watcher/auto state that resolve to
+// cfg.DefaultMode, as cmdServe would.
+func withProxyGlobals(t *testing.T, cfg *Config) {
+	t.Helper()
+	withAppConfig(t, cfg)
+	oldWatcher, oldAuto := configWatcher, autoSwitch
+	configWatcher = &ConfigWatcher{dir: t.TempDir(), config: cfg}
+	autoSwitch = newAutoState(cfg.DefaultMode)
+	t.Cleanup(func() { configWatcher, autoSwitch = oldWatcher, oldAuto })
+}
+
+func TestMatchRoute(t *testing.T) {
+	no := false
+	routes := []RouteRule{
+		{Path: "/v1/files*", Action: routeBlock},
+		{Path: "/v1/messages/batches", Action: routeRewrite, CountsForHealth: &no},
+		{Path: "/v1/batches", Action: routePassthrough},
+	}
+
+	tests := []struct {
+		path       string
+		wantAction string
+		wantHealth bool
+	}{
+		{"/v1/messages", routeRewrite, true},
+		{"/v1/messages/", routeRewrite, true},
+		{"/v1/messages/count_tokens", routeRewrite, false},
+		{"/v1/messages/batches", routeRewrite, false},
+		{"/v1/files", routeBlock, false},
+		{"/v1/files/abc", routeBlock, false},
+		{"/v1/complete", routeRewrite, true},
+		{"/v1/batches", routePassthrough, true},
+		{"/", routeRewrite, true},
+	}
+	for _, tt := range tests {
+		rr := matchRoute(routes, tt.path)
+		if rr.Action != tt.wantAction {
+			t.Errorf("%s: action = %q, want %q", tt.path, rr.Action, tt.wantAction)
+		}
+		if tt.wantAction == routeRewrite && rr.countsForHealth(tt.path) != tt.wantHealth {
+			t.Errorf("%s: countsForHealth = %v, want %v", tt.path, !tt.wantHealth, tt.wantHealth)
+		}
+	}
+}
+
+func TestProxyHandler_Routes(t *testing.T) {
+	var gotPath, gotBody string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(b)
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer backend.Close()
+
+	cfg := &Config{
+		DefaultMode: "gemini",
+		Modes: map[string]ModeConfig{
+			"gemini": {Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "gemini-pro"}}},
+		},
+		Routes: []RouteRule{
+			{Path: "/v1/admin*", Action: routeBlock},
+			{Path: "/v1/batches*", Action: routePassthrough},
+		},
+	}
+	withProxyGlobals(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, backend.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := proxyHandler(upstreams)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string // body seen upstream; "" with wantPath "" means not forwarded
+		wantPath   string
+	}{
+		{
+			name:       "messages are rewritten",
+			path:       "/v1/messages",
+			body:       `{"model":"claude-sonnet-4","messages":[]}`,
+			wantStatus: http.StatusTeapot,
+			wantBody:   `"gemini-pro"`,
+			wantPath:   "/v1/messages",
+		},
+		{
+			name:       "unlisted endpoint is rewritten",
+			path:       "/v1/complete",
+			body:       `{"model":"claude-sonnet-4"}`,
+			wantStatus: http.StatusTeapot,
+			wantBody:   `"gemini-pro"`,
+			wantPath:   "/v1/complete",
+		},
+		{
+			name:       "passthrough endpoint is not rewritten",
+			path:       "/v1/batches",
+			body:       `{"model":"claude-sonnet-4"}`,
+			wantStatus: http.StatusTeapot,
+			wantBody:   `"claude-sonnet-4"`,
+			wantPath:   "/v1/batches",
+		},
+		{
+			name:       "non-JSON body is forwarded, not rejected",
+			path:       "/v1/messages",
+			body:       "not json",
+			wantStatus: http.StatusTeapot,
+			wantBody:   "not json",
+			wantPath:   "/v1/messages",
+		},
+		{
+			name:       "blocked endpoint",
+			path:       "/v1/admin/keys",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, gotBody = "", ""
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("upstream path = %q, want %q", gotPath, tt.wantPath)
+			}
+			if !strings.Contains(gotBody, tt.wantBody) {
+				t.Errorf("upstream body = %q, want it to contain %q", gotBody, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestProxyHandler_CountTokensCountsForHealth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	yes := true
+	tests := []struct {
+		name         string
+		routes       []RouteRule
+		wantFailures bool
+	}{
+		{"default: not counted", nil, false},
+		{"rule sets countsForHealth", []RouteRule{{Path: countTokensPath, Action: routeRewrite, CountsForHealth: &yes}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{
+				DefaultMode: "antigravity",
+				Modes:       map[string]ModeConfig{"antigravity": {}, "claude": {}},
+				Routes:      tt.routes,
+			}
+			withProxyGlobals(t, cfg)
+			if err := os.WriteFile(filepath.Join(configWatcher.dir, "mode"), []byte("auto"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			upstreams, err := newUpstreamSet(cfg, backend.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, countTokensPath, strings.NewReader(`{"model":"claude-sonnet-4","messages":[]}`))
+			proxyHandler(upstreams)(httptest.NewRecorder(), req)
+
+			autoSwitch.mu.Lock()
+			failures := autoSwitch.failureCount
+			autoSwitch.mu.Unlock()
+			if (failures > 0) != tt.wantFailures {
+				t.Errorf("auto failureCount = %d, want counted = %v", failures, tt.wantFailures)
+			}
+		})
+	}
+}
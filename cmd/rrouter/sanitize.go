@@ -0,0 +1,327 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sanitizer step names accepted in ModeConfig.Sanitize.Steps.
+const (
+	sanitizeThinking         = "thinking"          // drop thinking content blocks
+	sanitizeRedactedThinking = "redacted_thinking" // drop redacted_thinking content blocks
+	sanitizeCacheControl     = "cache_control"     // remove cache_control markers
+	sanitizeSchemaKeywords   = "schema_keywords"   // strip unsupported JSON Schema keywords from tools
+	sanitizeServerTools      = "server_tools"      // drop server tools and their content blocks
+)
+
+// defaultSchemaKeywords are JSON Schema keywords Gemini function declarations reject.
+var defaultSchemaKeywords = []string{
+	"$schema", "$id", "$comment",
+	"additionalProperties", "patternProperties", "propertyNames",
+	"exclusiveMinimum", "exclusiveMaximum",
+}
+
+// serverToolBlockTypes are content blocks produced by Anthropic server tools.
+var serverToolBlockTypes = map[string]bool{
+	"server_tool_use":            true,
+	"web_search_tool_result":     true,
+	"web_fetch_tool_result":      true,
+	"code_execution_tool_result": true,
+}
+
+// SanitizeConfig selects which Anthropic-specific constructs are removed
+// before a request is sent to the mode's backend. Thinking blocks are
+// handled by the backend-aware compat layer (see applyThinkingCompat); the
+// "thinking" step drops them unconditionally.
+type SanitizeConfig struct {
+	Steps          []string `json:"steps"`
+	SchemaKeywords []string `json:"schemaKeywords,omitempty"` // overrides defaultSchemaKeywords
+}
+
+// sanitizeStats counts removed constructs by kind for one request.
+type sanitizeStats map[string]int
+
+// counterSet is a concurrency-safe set of named counters.
+type counterSet struct {
+	mu sync.Mutex
+	m  map[string]uint64
+}
+
+func (c *counterSet) add(stats map[string]int) {
+	if len(stats) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]uint64)
+	}
+	for k, v := range stats {
+		c.m[k] += uint64(v)
+	}
+}
+
+func (c *counterSet) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]uint64, len(c.m))
+	for k, v := range c.m {
+		out[k] = v
+	}
+	return out
+}
+
+// sanitizeTotals accumulates sanitizer removals since startup (for /health).
+var sanitizeTotals counterSet
+
+// String renders stats as "kind=n, ..." in a stable order.
+func (s sanitizeStats) String() string {
+	parts := make([]string, 0, len(s))
+	for _, k := range sortedKeys(s) {
+		parts = append(parts, k+"="+strconv.Itoa(s[k]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// validateSanitizeConfig warns about unknown sanitizer step names.
+func validateSanitizeConfig(cfg *SanitizeConfig, modeName string) {
+	if cfg == nil {
+		return
+	}
+	for _, step := range cfg.Steps {
+		switch step {
+		case sanitizeThinking, sanitizeRedactedThinking, sanitizeCacheControl, sanitizeSchemaKeywords, sanitizeServerTools:
+		default:
+			log.Printf("[WARN] Mode '%s': unknown sanitize step '%s' will be ignored", modeName, step)
+		}
+	}
+}
+
+// sanitizeRequest runs the configured sanitizer steps and the thinking-block
+// compat layer over the request data in place, and returns counts of what
+// was removed or converted.
+func sanitizeRequest(data map[string]interface{}, modeConfig *ModeConfig, mode string) sanitizeStats {
+	var steps []string
+	unsigned := unsignedThinkingText
+	if modeConfig != nil {
+		if modeConfig.Sanitize != nil {
+			steps = modeConfig.Sanitize.Steps
+		}
+		if modeConfig.UnsignedThinking != "" {
+			unsigned = modeConfig.UnsignedThinking
+		}
+	}
+	stats := sanitizeStats{}
+
+	dropBlocks := make(map[string]bool)
+	for _, step := range steps {
+		switch step {
+		case sanitizeThinking:
+			dropBlocks["thinking"] = true
+		case sanitizeRedactedThinking:
+			dropBlocks["redacted_thinking"] = true
+		case sanitizeServerTools:
+			for t := range serverToolBlockTypes {
+				dropBlocks[t] = true
+			}
+		}
+	}
+	if len(dropBlocks) > 0 {
+		if messages, ok := data["messages"].([]interface{}); ok {
+			data["messages"] = stripContentBlocks(messages, dropBlocks, stats)
+		}
+	}
+
+	for _, step := range steps {
+		switch step {
+		case sanitizeCacheControl:
+			stripCacheControl(data, stats)
+		case sanitizeSchemaKeywords:
+			keywords := defaultSchemaKeywords
+			if modeConfig != nil && modeConfig.Sanitize != nil && len(modeConfig.Sanitize.SchemaKeywords) > 0 {
+				keywords = modeConfig.Sanitize.SchemaKeywords
+			}
+			stripSchemaKeywords(data, keywords, stats)
+		case sanitizeServerTools:
+			stripServerTools(data, stats)
+		}
+	}
+
+	applyThinkingCompat(data, backendFor(mode, modeConfig), unsigned, stats)
+
+	if len(stats) == 0 {
+		return nil
+	}
+	return stats
+}
+
+// stripContentBlocks removes content blocks whose type is in drop.
+// If a message's content becomes empty after stripping, the message is removed entirely.
+func stripContentBlocks(messages []interface{}, drop map[string]bool, stats sanitizeStats) []interface{} {
+	result := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		msgMap, ok := msg.(map[string]interface{})
+		if !ok {
+			result = append(result, msg)
+			continue
+		}
+
+		// String content or other format - keep as is
+		contentArr, isArray := msgMap["content"].([]interface{})
+		if !isArray {
+			result = append(result, msg)
+			continue
+		}
+
+		filteredContent := make([]interface{}, 0, len(contentArr))
+		for _, block := range contentArr {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				filteredContent = append(filteredContent, block)
+				continue
+			}
+			blockType, _ := blockMap["type"].(string)
+			if drop[blockType] {
+				stats[blockType]++
+				continue
+			}
+			filteredContent = append(filteredContent, block)
+		}
+
+		// If content is empty after filtering, skip this message entirely
+		if len(filteredContent) == 0 {
+			stats["empty_message"]++
+			continue
+		}
+
+		// Create new message with filtered content
+		newMsg := make(map[string]interface{}, len(msgMap))
+		for k, v := range msgMap {
+			newMsg[k] = v
+		}
+		newMsg["content"] = filteredContent
+		result = append(result, newMsg)
+	}
+	return result
+}
+
+// stripCacheControl removes cache_control markers from system blocks,
+// message content blocks (including nested tool_result content) and tools.
+func stripCacheControl(data map[string]interface{}, stats sanitizeStats) {
+	var strip func(v interface{})
+	strip = func(v interface{}) {
+		switch t := v.(type) {
+		case []interface{}:
+			for _, item := range t {
+				strip(item)
+			}
+		case map[string]interface{}:
+			if _, ok := t["cache_control"]; ok {
+				delete(t, "cache_control")
+				stats[sanitizeCacheControl]++
+			}
+			if content, ok := t["content"]; ok {
+				strip(content)
+			}
+		}
+	}
+
+	strip(data["system"])
+	strip(data["tools"])
+	if messages, ok := data["messages"].([]interface{}); ok {
+		for _, msg := range messages {
+			if msgMap, ok := msg.(map[string]interface{}); ok {
+				strip(msgMap["content"])
+			}
+		}
+	}
+}
+
+// stripSchemaKeywords removes the given keywords from every tool's
+// input_schema, recursing into nested schemas. Keys of "properties" (and
+// definition maps) are property names, not keywords, and are preserved.
+func stripSchemaKeywords(data map[string]interface{}, keywords []string, stats sanitizeStats) {
+	tools, ok := data["tools"].([]interface{})
+	if !ok {
+		return
+	}
+	kw := make(map[string]bool, len(keywords))
+	for _, k := range keywords {
+		kw[k] = true
+	}
+
+	var walk func(schema interface{})
+	walk = func(schema interface{}) {
+		switch s := schema.(type) {
+		case []interface{}:
+			for _, item := range s {
+				walk(item)
+			}
+		case map[string]interface{}:
+			for _, k := range sortedKeys(s) {
+				if kw[k] {
+					delete(s, k)
+					stats["schema:"+k]++
+					continue
+				}
+				switch k {
+				case "properties", "$defs", "definitions":
+					if props, ok := s[k].(map[string]interface{}); ok {
+						for _, name := range sortedKeys(props) {
+							walk(props[name])
+						}
+					}
+				default:
+					walk(s[k])
+				}
+			}
+		}
+	}
+
+	for _, tool := range tools {
+		if toolMap, ok := tool.(map[string]interface{}); ok {
+			walk(toolMap["input_schema"])
+		}
+	}
+}
+
+// stripServerTools drops tool definitions for Anthropic server tools (those
+// with a versioned "type" such as web_search_20250305) and clears a
+// tool_choice that names a dropped tool.
+func stripServerTools(data map[string]interface{}, stats sanitizeStats) {
+	tools, ok := data["tools"].([]interface{})
+	if !ok {
+		return
+	}
+	removed := make(map[string]bool)
+	kept := make([]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]interface{})
+		if ok {
+			if toolType, _ := toolMap["type"].(string); toolType != "" && toolType != "custom" {
+				name, _ := toolMap["name"].(string)
+				removed[name] = true
+				stats["server_tool:"+toolType]++
+				continue
+			}
+		}
+		kept = append(kept, tool)
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	if len(kept) == 0 {
+		delete(data, "tools")
+		delete(data, "tool_choice")
+		return
+	}
+	data["tools"] = kept
+	if choice, ok := data["tool_choice"].(map[string]interface{}); ok {
+		if name, _ := choice["name"].(string); removed[name] {
+			delete(data, "tool_choice")
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func mustParse(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("bad fixture: %v", err)
+	}
+	return data
+}
+
+func TestSanitizeRequest_ConfiguredSteps(t *testing.T) {
+	body := `{
+		"system": [{"type": "text", "text": "s", "cache_control": {"type": "ephemeral"}}],
+		"messages": [
+			{"role": "assistant", "content": [
+				{"type": "redacted_thinking", "data": "x"},
+				{"type": "thinking", "thinking": "kept", "signature": "sig"},
+				{"type": "server_tool_use", "id": "s1"},
+				{"type": "text", "text": "t", "cache_control": {"type": "ephemeral"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "content": [{"type": "text", "text": "r", "cache_control": {"type": "ephemeral"}}]}
+			]}
+		],
+		"tools": [
+			{"name": "Read", "input_schema": {
+				"$schema": "http://json-schema.org/draft-07/schema#",
+				"type": "object",
+				"additionalProperties": false,
+				"properties": {
+					"additionalProperties": {"type": "string", "$comment": "a property, not a keyword"},
+					"nested": {"type": "object", "additionalProperties": false}
+				}
+			}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"tool_choice": {"type": "tool", "name": "web_search"}
+	}`
+
+	mc := &ModeConfig{Backend: backendAnthropic, Sanitize: &SanitizeConfig{Steps: []string{
+		sanitizeRedactedThinking, sanitizeCacheControl, sanitizeSchemaKeywords, sanitizeServerTools,
+	}}}
+	data := mustParse(t, body)
+	stats := sanitizeRequest(data, mc, "antigravity")
+
+	want := sanitizeStats{
+		"redacted_thinking":               1,
+		"server_tool_use":                 1,
+		"cache_control":                   3,
+		"schema:$schema":                  1,
+		"schema:additionalProperties":     2,
+		"schema:$comment":                 1,
+		"server_tool:web_search_20250305": 1,
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("stats = %v, want %v", stats, want)
+	}
+
+	expected := mustParse(t, `{
+		"system": [{"type": "text", "text": "s"}],
+		"messages": [
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "kept", "signature": "sig"},
+				{"type": "text", "text": "t"}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "content": [{"type": "text", "text": "r"}]}
+			]}
+		],
+		"tools": [
+			{"name": "Read", "input_schema": {
+				"type": "object",
+				"properties": {
+					"additionalProperties": {"type": "string"},
+					"nested": {"type": "object"}
+				}
+			}}
+		]
+	}`)
+	if !reflect.DeepEqual(data, expected) {
+		got, _ := json.MarshalIndent(data, "", "  ")
+		t.Errorf("sanitized request mismatch:\n%s", got)
+	}
+}
+
+func TestSanitizeRequest_CustomSchemaKeywords(t *testing.T) {
+	data := mustParse(t, `{"tools": [{"name": "t", "input_schema": {"type": "object", "format": "x", "$schema": "s"}}]}`)
+	mc := &ModeConfig{Sanitize: &SanitizeConfig{
+		Steps:          []string{sanitizeSchemaKeywords},
+		SchemaKeywords: []string{"format"},
+	}}
+
+	stats := sanitizeRequest(data, mc, "antigravity")
+	if !reflect.DeepEqual(stats, sanitizeStats{"schema:format": 1}) {
+		t.Errorf("stats = %v", stats)
+	}
+	schema := data["tools"].([]interface{})[0].(map[string]interface{})["input_schema"].(map[string]interface{})
+	if _, ok := schema["$schema"]; !ok {
+		t.Error("$schema should be kept when schemaKeywords overrides the defaults")
+	}
+}
+
+func TestSanitizeRequest_ThinkingStepDropsSignedBlocks(t *testing.T) {
+	data := mustParse(t, `{"messages": [{"role": "assistant", "content": [
+		{"type": "thinking", "thinking": "x", "signature": "sig"},
+		{"type": "text", "text": "hi"}
+	]}]}`)
+	mc := &ModeConfig{Backend: backendAnthropic, Sanitize: &SanitizeConfig{Steps: []string{sanitizeThinking}}}
+
+	stats := sanitizeRequest(data, mc, "claude")
+	if !reflect.DeepEqual(stats, sanitizeStats{"thinking": 1}) {
+		t.Errorf("stats = %v", stats)
+	}
+}
+
+func TestServerToolsRemovedEntirely(t *testing.T) {
+	data := mustParse(t, `{"tools": [{"type": "bash_20250124", "name": "bash"}], "tool_choice": {"type": "auto"}}`)
+	stripServerTools(data, sanitizeStats{})
+	if _, ok := data["tools"]; ok {
+		t.Error("tools should be removed when only server tools were present")
+	}
+	if _, ok := data["tool_choice"]; ok {
+		t.Error("tool_choice should be removed along with tools")
+	}
+}
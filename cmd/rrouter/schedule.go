@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleConfig switches the default mode by time of day. The first rule
+// covering the current time picks the mode, else Default. The daemon only
+// writes the mode file when the scheduled mode changes, so a manual mode
+// switch holds until the next scheduled change.
+type ScheduleConfig struct {
+	Rules    []ScheduleRule `json:"rules"`
+	Default  string         `json:"default,omitempty"`  // mode outside every rule; "" = leave the mode alone
+	Timezone string         `json:"timezone,omitempty"` // IANA name, default local time
+}
+
+// ScheduleRule selects Mode on Days between Start and End ("HH:MM"). An End
+// at or before Start runs past midnight into the next day.
+type ScheduleRule struct {
+	Days  string `json:"days,omitempty"` // "mon-fri", "sat,sun", "weekdays", "weekends"; default every day
+	Start string `json:"start"`
+	End   string `json:"end"`
+	Mode  string `json:"mode"`
+}
+
+func (r ScheduleRule) String() string {
+	days := r.Days
+	if days == "" {
+		days = "daily"
+	}
+	return fmt.Sprintf("%s %s-%s", days, r.Start, r.End)
+}
+
+// scheduleTickInterval is how often the daemon checks the schedule.
+const scheduleTickInterval = 30 * time.Second
+
+// schedule is a parsed ScheduleConfig.
+type schedule struct {
+	cfg     *ScheduleConfig
+	rules   []scheduleRule
+	loc     *time.Location
+	started time.Time // stands in for the last change when there is none
+}
+
+type scheduleRule struct {
+	days       [7]bool // indexed by time.Weekday
+	start, end int     // minutes since midnight
+	mode       string
+}
+
+// covers reports whether the rule is active at the given weekday and minute.
+func (r scheduleRule) covers(day time.Weekday, minute int) bool {
+	if r.start < r.end {
+		return r.days[day] && minute >= r.start && minute < r.end
+	}
+	// Past midnight: the evening of a listed day or the morning after one
+	return (r.days[day] && minute >= r.start) || (r.days[(day+6)%7] && minute < r.end)
+}
+
+// newSchedule parses cfg; a nil cfg or one without rules or default
+// yields a nil schedule.
+func newSchedule(cfg *ScheduleConfig) (*schedule, error) {
+	if cfg == nil || (len(cfg.Rules) == 0 && cfg.Default == "") {
+		return nil, nil
+	}
+	s := &schedule{cfg: cfg, loc: time.Local, started: time.Now()}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone %q: %w", cfg.Timezone, err)
+		}
+		s.loc = loc
+	}
+	for i, r := range cfg.Rules {
+		days, err := parseScheduleDays(r.Days)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+		start, err := parseScheduleClock(r.Start)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d start: %w", i+1, err)
+		}
+		end, err := parseScheduleClock(r.End)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d end: %w", i+1, err)
+		}
+		if r.Mode == "" {
+			return nil, fmt.Errorf("rule #%d has no mode", i+1)
+		}
+		s.rules = append(s.rules, scheduleRule{days: days, start: start, end: end, mode: r.Mode})
+	}
+	return s, nil
+}
+
+var scheduleDayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseScheduleDays parses a comma-separated list of days ("mon"), ranges
+// ("mon-fri", may wrap) and the words daily, weekdays and weekends.
+func parseScheduleDays(spec string) ([7]bool, error) {
+	var days [7]bool
+	if spec == "" {
+		spec = "daily"
+	}
+	for _, tok := range strings.Split(strings.ToLower(spec), ",") {
+		tok = strings.TrimSpace(tok)
+		switch tok {
+		case "daily", "*":
+			tok = "sun-sat"
+		case "weekdays":
+			tok = "mon-fri"
+		case "weekends":
+			tok = "sat-sun"
+		}
+		from, to, isRange := strings.Cut(tok, "-")
+		first, ok := parseScheduleDay(from)
+		if !ok {
+			return days, fmt.Errorf("unknown day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = parseScheduleDay(to); !ok {
+				return days, fmt.Errorf("unknown day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseScheduleDay(name string) (time.Weekday, bool) {
+	for i, short := range scheduleDayNames {
+		if name == short || name == strings.ToLower(time.Weekday(i).String()) {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseScheduleClock parses "HH:MM" (00:00-24:00) into minutes since midnight.
+func parseScheduleClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// at returns the scheduled mode at t and the index of the rule that set it
+// (-1 for the default).
+func (s *schedule) at(t time.Time) (string, int) {
+	t = t.In(s.loc)
+	minute := t.Hour()*60 + t.Minute()
+	for i, r := range s.rules {
+		if r.covers(t.Weekday(), minute) {
+			return r.mode, i
+		}
+	}
+	return s.cfg.Default, -1
+}
+
+// changes returns the times in the week around t at which the scheduled
+// mode changes, in order.
+func (s *schedule) changes(t time.Time) []time.Time {
+	t = t.In(s.loc)
+	var edges []time.Time
+	for offset := -8; offset <= 8; offset++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, s.loc)
+		for _, r := range s.rules {
+			if !r.days[day.Weekday()] {
+				continue
+			}
+			end := r.end
+			if r.end <= r.start {
+				end += 24 * 60
+			}
+			for _, minute := range []int{r.start, end} {
+				edges = append(edges, time.Date(day.Year(), day.Month(), day.Day(), 0, minute, 0, 0, s.loc))
+			}
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].Before(edges[j]) })
+
+	var changes []time.Time
+	for _, e := range edges {
+		if len(changes) > 0 && e.Equal(changes[len(changes)-1]) {
+			continue
+		}
+		before, _ := s.at(e.Add(-time.Minute))
+		after, _ := s.at(e)
+		if before != after {
+			changes = append(changes, e)
+		}
+	}
+	return changes
+}
+
+// boundaries returns the last scheduled change at or before t and the next
+// one after it; either is zero if there is none within a week.
+func (s *schedule) boundaries(t time.Time) (last, next time.Time) {
+	for _, c := range s.changes(t) {
+		if !c.After(t) {
+			last = c
+		} else if next.IsZero() {
+			next = c
+		}
+	}
+	return last, next
+}
+
+// apply writes the scheduled mode to path unless the file was written since
+// the last scheduled change (a manual switch) or already holds that mode. A
+// schedule whose mode never changes (only a default) counts from when it was
+// loaded instead.
+func (s *schedule) apply(path string, now time.Time) error {
+	mode, rule := s.at(now)
+	if mode == "" {
+		return nil
+	}
+	last, _ := s.boundaries(now)
+	if last.IsZero() {
+		last = s.started
+	}
+	current := ""
+	if info, err := os.Stat(path); err == nil {
+		if info.ModTime().After(last) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		current = strings.TrimSpace(string(content))
+	}
+	if current == mode {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(mode), 0644); err != nil {
+		return fmt.Errorf("failed to write mode file: %w", err)
+	}
+	log.Printf("[SCHEDULE] %s -> %s (%s)", current, mode, s.describe(rule))
+	return nil
+}
+
+// runSchedule applies the daemon's current schedule, if any, to the mode
+// file at path now and on every tick.
+func runSchedule(path string) {
+	ticker := time.NewTicker(scheduleTickInterval)
+	defer ticker.Stop()
+	for now := time.Now(); ; now = <-ticker.C {
+		s := serveSchedule.Load()
+		if s == nil {
+			continue
+		}
+		if err := s.apply(path, now); err != nil {
+			log.Printf("[SCHEDULE] Error: %v", err)
+		}
+	}
+}
+
+// reloadSchedule replaces the daemon's schedule after config.json changes.
+// An unchanged schedule is kept, so a manual switch still holds.
+func reloadSchedule(cfg *ScheduleConfig) {
+	cur := serveSchedule.Load()
+	if cur != nil && reflect.DeepEqual(cur.cfg, cfg) {
+		return
+	}
+	s, err := newSchedule(cfg)
+	if err != nil {
+		log.Printf("[WARN] Schedule is disabled: %v", err)
+	}
+	if s == nil && cur == nil {
+		return
+	}
+	serveSchedule.Store(s)
+	if s == nil {
+		log.Printf("[SCHEDULE] Removed")
+	} else {
+		log.Printf("[SCHEDULE] Reloaded")
+	}
+}
+
+// describe names rule i, or the default.
+func (s *schedule) describe(i int) string {
+	if i < 0 {
+		return "default"
+	}
+	return s.cfg.Rules[i].String()
+}
+
+// HealthInfo reports the active entry, the next change and whether the
+// current mode differs from the scheduled one.
+func (s *schedule) HealthInfo(now time.Time, currentMode string) map[string]interface{} {
+	mode, rule := s.at(now)
+	info := map[string]interface{}{
+		"active":        s.describe(rule),
+		"scheduledMode": mode,
+		"override":      mode != "" && currentMode != "" && currentMode != mode,
+	}
+	if _, next := s.boundaries(now); !next.IsZero() {
+		nextMode, nextRule := s.at(next)
+		info["next"] = s.describe(nextRule)
+		info["nextMode"] = nextMode
+		info["nextChange"] = next.Format(time.RFC3339)
+	}
+	return info
+}
+
+// validateSchedule warns about an unparsable schedule and unknown modes.
+func validateSchedule(cfg *Config) {
+	sc := cfg.Schedule
+	if sc == nil {
+		return
+	}
+	if _, err := newSchedule(sc); err != nil {
+		log.Printf("[WARN] Schedule is disabled: %v", err)
+		return
+	}
+	modes := []string{sc.Default}
+	for _, r := range sc.Rules {
+		modes = append(modes, r.Mode)
+	}
+	for _, mode := range modes {
+		if _, ok := cfg.Modes[mode]; !ok && mode != "" && mode != "auto" {
+			log.Printf("[WARN] Schedule: unknown mode '%s'", mode)
+		}
+	}
+}
+
+// showScheduleStatus prints the schedule section of `rrouter status`.
+func showScheduleStatus(cfg *Config, now time.Time) {
+	s, err := newSchedule(cfg.Schedule)
+	if s == nil {
+		if err != nil {
+			fmt.Printf("  Schedule:    invalid (%v)\n", err)
+		}
+		return
+	}
+	info := s.HealthInfo(now, getCurrentMode())
+	fmt.Printf("  Schedule:    %s -> %s\n", info["active"], info["scheduledMode"])
+	if next, ok := info["next"]; ok {
+		at, _ := time.Parse(time.RFC3339, info["nextChange"].(string))
+		fmt.Printf("  Next:        %s -> %s at %s (in %s)\n", next, info["nextMode"], at.Format("Mon 15:04"), at.Sub(now).Round(time.Minute))
+	}
+	if info["override"] == true {
+		fmt.Println("  Override:    mode set manually; schedule resumes at the next change")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Monday 5 January 2026, UTC
+func testScheduleTime(day int, clock string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", "2026-01-05 "+clock)
+	if err != nil {
+		panic(err)
+	}
+	return t.AddDate(0, 0, day)
+}
+
+func newTestSchedule(t *testing.T) *schedule {
+	t.Helper()
+	s, err := newSchedule(&ScheduleConfig{
+		Timezone: "UTC",
+		Default:  "antigravity",
+		Rules: []ScheduleRule{
+			{Days: "weekdays", Start: "09:00", End: "18:00", Mode: "auto"},
+			{Days: "sat", Start: "22:00", End: "02:00", Mode: "claude"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestParseScheduleDays(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string // sun..sat
+		wantErr bool
+	}{
+		{"", "1111111", false},
+		{"weekdays", "0111110", false},
+		{"weekends", "1000001", false},
+		{"Mon, Wednesday", "0101000", false},
+		{"fri-mon", "1100011", false},
+		{"mon-funday", "", true},
+	}
+	for _, tt := range tests {
+		days, err := parseScheduleDays(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseScheduleDays(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		var got strings.Builder
+		for _, on := range days {
+			if on {
+				got.WriteByte('1')
+			} else {
+				got.WriteByte('0')
+			}
+		}
+		if got.String() != tt.want {
+			t.Errorf("parseScheduleDays(%q) = %s, want %s", tt.spec, got.String(), tt.want)
+		}
+	}
+}
+
+func TestNewSchedule_Errors(t *testing.T) {
+	tests := []ScheduleConfig{
+		{Rules: []ScheduleRule{{Start: "9:00", End: "25:00", Mode: "auto"}}},
+		{Rules: []ScheduleRule{{Start: "09:00", End: "18:00"}}},
+		{Rules: []ScheduleRule{{Days: "someday", Start: "09:00", End: "18:00", Mode: "auto"}}},
+		{Default: "claude", Timezone: "Nowhere/Special"},
+	}
+	for i, cfg := range tests {
+		if _, err := newSchedule(&cfg); err == nil {
+			t.Errorf("config #%d: expected error", i+1)
+		}
+	}
+	if s, err := newSchedule(&ScheduleConfig{}); s != nil || err != nil {
+		t.Errorf("empty schedule = %v, %v; want nil, nil", s, err)
+	}
+}
+
+func TestSchedule_At(t *testing.T) {
+	s := newTestSchedule(t)
+	tests := []struct {
+		day   int
+		clock string
+		want  string
+	}{
+		{0, "10:00", "auto"},
+		{0, "08:59", "antigravity"},
+		{0, "18:00", "antigravity"},
+		{4, "17:59", "auto"},
+		{5, "10:00", "antigravity"}, // saturday
+		{5, "23:00", "claude"},
+		{6, "01:30", "claude"}, // past midnight
+		{6, "02:00", "antigravity"},
+		{7, "01:00", "antigravity"}, // monday morning: the rule is saturday only
+	}
+	for _, tt := range tests {
+		now := testScheduleTime(tt.day, tt.clock)
+		if got, _ := s.at(now); got != tt.want {
+			t.Errorf("at(%s) = %q, want %q", now.Format("Mon 15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestSchedule_Boundaries(t *testing.T) {
+	s := newTestSchedule(t)
+	tests := []struct {
+		day                int
+		clock              string
+		wantLast, wantNext string
+	}{
+		{0, "10:00", "Mon 09:00", "Mon 18:00"},
+		{0, "09:00", "Mon 09:00", "Mon 18:00"},
+		{4, "20:00", "Fri 18:00", "Sat 22:00"},
+		{6, "12:00", "Sun 02:00", "Mon 09:00"},
+	}
+	for _, tt := range tests {
+		now := testScheduleTime(tt.day, tt.clock)
+		last, next := s.boundaries(now)
+		if got := last.Format("Mon 15:04"); got != tt.wantLast {
+			t.Errorf("%s: last = %s, want %s", now.Format("Mon 15:04"), got, tt.wantLast)
+		}
+		if got := next.Format("Mon 15:04"); got != tt.wantNext {
+			t.Errorf("%s: next = %s, want %s", now.Format("Mon 15:04"), got, tt.wantNext)
+		}
+	}
+
+	// Adjacent rules with the same mode are one period
+	merged, _ := newSchedule(&ScheduleConfig{Timezone: "UTC", Default: "claude", Rules: []ScheduleRule{
+		{Start: "09:00", End: "12:00", Mode: "auto"},
+		{Start: "12:00", End: "18:00", Mode: "auto"},
+	}})
+	if _, next := merged.boundaries(testScheduleTime(0, "10:00")); next.Format("15:04") != "18:00" {
+		t.Errorf("merged next = %s, want 18:00", next.Format("15:04"))
+	}
+}
+
+func TestSchedule_Apply(t *testing.T) {
+	s := newTestSchedule(t)
+	path := filepath.Join(t.TempDir(), "mode")
+	now := testScheduleTime(0, "10:00") // auto since 09:00
+
+	read := func() string {
+		b, _ := os.ReadFile(path)
+		return string(b)
+	}
+	writeAt := func(mode string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(mode), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No mode file yet
+	if err := s.apply(path, now); err != nil || read() != "auto" {
+		t.Fatalf("missing file: mode = %q, err = %v", read(), err)
+	}
+
+	// Set before the boundary: the schedule takes over
+	writeAt("claude", testScheduleTime(0, "08:00"))
+	if err := s.apply(path, now); err != nil || read() != "auto" {
+		t.Errorf("stale file: mode = %q, err = %v", read(), err)
+	}
+
+	// Manual switch after the boundary holds until the next one
+	writeAt("claude", testScheduleTime(0, "09:30"))
+	if err := s.apply(path, now); err != nil || read() != "claude" {
+		t.Errorf("manual override: mode = %q, err = %v", read(), err)
+	}
+	if err := s.apply(path, testScheduleTime(0, "18:01")); err != nil || read() != "antigravity" {
+		t.Errorf("next boundary: mode = %q, err = %v", read(), err)
+	}
+}
+
+func TestSchedule_ApplyDefaultOnly(t *testing.T) {
+	s, err := newSchedule(&ScheduleConfig{Timezone: "UTC", Default: "antigravity"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "mode")
+	now := time.Now()
+
+	// Written before the schedule was loaded: the default takes over
+	if err := os.WriteFile(path, []byte("claude"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := now.Add(-48 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.apply(path, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "antigravity" {
+		t.Errorf("stale file: mode = %q, want antigravity", b)
+	}
+
+	// A manual switch after that holds
+	later := now.Add(time.Minute)
+	if err := os.WriteFile(path, []byte("claude"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.apply(path, later.Add(time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "claude" {
+		t.Errorf("manual override: mode = %q, want claude", b)
+	}
+}
+
+func TestSchedule_HealthInfo(t *testing.T) {
+	s := newTestSchedule(t)
+	info := s.HealthInfo(testScheduleTime(0, "10:00"), "claude")
+	if info["active"] != "weekdays 09:00-18:00" || info["scheduledMode"] != "auto" || info["override"] != true {
+		t.Errorf("active info = %v", info)
+	}
+	if info["nextMode"] != "antigravity" || info["next"] != "default" || info["nextChange"] != "2026-01-05T18:00:00Z" {
+		t.Errorf("next info = %v", info)
+	}
+}
+
+func TestReloadSchedule(t *testing.T) {
+	t.Cleanup(func() { serveSchedule.Store(nil) })
+	cfg := &ScheduleConfig{Timezone: "UTC", Default: "antigravity"}
+
+	reloadSchedule(cfg)
+	first := serveSchedule.Load()
+	if first == nil {
+		t.Fatal("schedule not loaded")
+	}
+
+	// Same schedule in a reloaded config: kept, so manual switches hold
+	reloadSchedule(&ScheduleConfig{Timezone: "UTC", Default: "antigravity"})
+	if serveSchedule.Load() != first {
+		t.Error("unchanged schedule was rebuilt")
+	}
+
+	reloadSchedule(&ScheduleConfig{Timezone: "UTC", Default: "claude"})
+	if s := serveSchedule.Load(); s == first || s == nil || s.cfg.Default != "claude" {
+		t.Errorf("changed schedule not reloaded: %+v", s)
+	}
+
+	reloadSchedule(nil)
+	if serveSchedule.Load() != nil {
+		t.Error("removed schedule still active")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,6 +16,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -24,12 +26,15 @@ import (
 const modeFilePath = ".rrouter/mode"
 
 var (
-	requestCount  atomic.Uint64
-	appConfig     *Config
-	upstreamURL   string
-	listenAddr    string
-	configWatcher *ConfigWatcher
-	autoSwitch    *autoState
+	requestCount   atomic.Uint64
+	appConfig      *Config
+	upstreamURL    string
+	listenAddr     string
+	configWatcher  *ConfigWatcher
+	autoSwitch     *autoState
+	sessionPins    *sessionTable // nil unless sessions.enabled
+	serveUpstreams *upstreamSet
+	serveSchedule  atomic.Pointer[schedule] // nil unless a valid schedule is configured
 )
 
 // proxyResult captures per-request error info from the reverse proxy ErrorHandler.
@@ -41,111 +46,181 @@ type proxyResult struct {
 
 type contextKey string
 
-const proxyResultKey contextKey = "proxyResult"
-
-// stripThinkingBlocks removes thinking blocks from messages for non-Claude backends.
-// If a message's content becomes empty after stripping, the message is removed entirely.
-func stripThinkingBlocks(messages []interface{}) []interface{} {
-	result := make([]interface{}, 0, len(messages))
-	for _, msg := range messages {
-		msgMap, ok := msg.(map[string]interface{})
-		if !ok {
-			result = append(result, msg)
-			continue
-		}
+const (
+	proxyResultKey contextKey = "proxyResult"
+	routeInfoKey   contextKey = "routeInfo"
+	intentKey      contextKey = "intent" // mode in effect when the request arrived
+	profileKey     contextKey = "profile"
+	agentKey       contextKey = "agent"  // agentRoute of a request moved by agentModeFor
+	targetKey      contextKey = "target" // mode of a request sent by serveDirect
+)
 
-		content, hasContent := msgMap["content"]
-		if !hasContent {
-			result = append(result, msg)
-			continue
-		}
+// routeInfo carries per-attempt routing decisions from proxyHandler to the
+// reverse proxy's Director and ModifyResponse hooks.
+type routeInfo struct {
+	mode          string // resolved target mode for this attempt
+	originalModel string // model requested by the client
+	model         string // model sent upstream
+	restoreModel  bool   // rewrite response "model" back to originalModel
+	backend       string // backend family of the mode (see backendFor)
+	experiment    string // A/B mapping match pattern, if a split was applied
+	variant       string // A/B target chosen for this attempt
+	agent         string // detected agent name, if any
+	agentGroup    string // agent group of agent (agentGroupNone if unlisted)
+	shadow        bool   // mirrored request; response is discarded
+	usage         *tokenUsage
+	stream        *streamMonitor
+}
 
-		// Handle content as array of blocks
-		contentArr, isArray := content.([]interface{})
-		if !isArray {
-			// String content or other format - keep as is
-			result = append(result, msg)
-			continue
-		}
+// requestError is a client-facing error raised by rrouter itself (not the
+// upstream). It is rendered in Anthropic error format.
+type requestError struct {
+	status  int
+	errType string
+	message string
+}
 
-		// Filter out thinking blocks
-		filteredContent := make([]interface{}, 0, len(contentArr))
-		for _, block := range contentArr {
-			blockMap, ok := block.(map[string]interface{})
-			if !ok {
-				filteredContent = append(filteredContent, block)
-				continue
-			}
-			blockType, _ := blockMap["type"].(string)
-			if blockType == "thinking" {
-				// Skip thinking blocks
-				continue
-			}
-			filteredContent = append(filteredContent, block)
-		}
+func (e *requestError) Error() string {
+	return e.message
+}
 
-		// If content is empty after filtering, skip this message entirely
-		if len(filteredContent) == 0 {
-			continue
-		}
+// writeAnthropicError writes an error body in Anthropic Messages API format.
+func writeAnthropicError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
 
-		// Create new message with filtered content
-		newMsg := make(map[string]interface{})
-		for k, v := range msgMap {
-			newMsg[k] = v
-		}
-		newMsg["content"] = filteredContent
-		result = append(result, newMsg)
+// writeRequestError reports a failed body rewrite: requestErrors carry
+// their own status, anything else is a 400.
+func writeRequestError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		writeAnthropicError(w, reqErr.status, reqErr.errType, reqErr.message)
+		return
 	}
-	return result
+	http.Error(w, "Error processing request", http.StatusBadRequest)
+}
+
+// rewriteResult is the outcome of rewriting one request body for a mode.
+type rewriteResult struct {
+	body          []byte
+	originalModel string // model requested by the client ("" if absent)
+	model         string // model sent upstream
+	experiment    string // match pattern of the A/B mapping used, if any
+	variant       string // A/B target chosen (== model)
+	agent         string // detected agent name ("" if none)
+	agentGroup    string // agent group of agent (agentGroupNone if unlisted)
+	sanitized     sanitizeStats
 }
 
 func modifyRequestBody(body []byte, modeConfig *ModeConfig, mode string) ([]byte, error) {
+	res, err := rewriteRequest(body, modeConfig, mode, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return res.body, nil
+}
+
+// rewriteRequest applies model rewriting, agent/context routing, parameter
+// transforms and content stripping for the given mode. sessionKey keeps
+// sticky A/B splits stable and may be empty; header feeds header agent
+// detectors and may be nil.
+func rewriteRequest(body []byte, modeConfig *ModeConfig, mode, sessionKey string, header http.Header) (*rewriteResult, error) {
 	var data map[string]interface{}
 	if err := json.Unmarshal(body, &data); err != nil {
 		return nil, fmt.Errorf("invalid JSON: %w", err)
 	}
 
+	res := &rewriteResult{}
+
 	if modelVal, ok := data["model"]; ok {
 		if modelStr, ok := modelVal.(string); ok {
 			originalModel := modelStr
+			res.originalModel = originalModel
 
 			// Step 1: Apply standard model rewriting (existing behavior)
 			newModel := rewriteModelWithConfig(modelStr, modeConfig)
+			if m := findMapping(modelStr, modeConfig); m != nil && len(m.Targets) > 0 {
+				newModel = m.pickTarget(originalModel, sessionKey)
+				if m.totalWeight() > 0 {
+					res.experiment, res.variant = m.Match, newModel
+					log.Printf("[Mode: %s] A/B split %s: %s -> %s", mode, m.Match, originalModel, newModel)
+				}
+			}
 
 			// Step 2: Agent-type routing override (only when agentRouting is configured and enabled)
+			var groupMapping *ModelMapping
 			if modeConfig != nil && modeConfig.AgentRouting != nil && modeConfig.AgentRouting.Enabled {
-				agentName := detectAgentName(data)
+				agentName := detectAgent(modeConfig.AgentRouting, data, header)
 				if agentName != "" {
-					agentType := classifyAgent(agentName, modeConfig.AgentRouting)
-					switch agentType {
-					case AgentTypeGroup1:
-						newModel = modeConfig.AgentRouting.Group1Model
-						log.Printf("[Mode: %s] Agent routing: %s (group1) -> %s", mode, agentName, newModel)
-					case AgentTypeGroup2:
-						log.Printf("[Mode: %s] Agent routing: %s (group2) -> %s (standard)", mode, agentName, newModel)
-					default:
+					res.agent, res.agentGroup = agentName, agentGroupNone
+					if g := matchAgentGroup(agentName, modeConfig.AgentRouting); g != nil {
+						res.agentGroup = g.label()
+						newModel, groupMapping = g.rewrite(originalModel, newModel, sessionKey)
+						log.Printf("[Mode: %s] Agent routing: %s (%s, %s) -> %s", mode, agentName, g.Name, g.target(), newModel)
+					} else {
 						log.Printf("[Mode: %s] Agent routing: %s (unknown, fallback) -> %s", mode, agentName, newModel)
 					}
 				}
 			}
 
+			// Step 3: Context-size routing (reroute or reject oversized prompts)
+			if modeConfig != nil && modeConfig.ContextRouting != nil {
+				tokens := estimateRequestTokens(data)
+				routed, err := applyContextRouting(modeConfig.ContextRouting, originalModel, newModel, tokens)
+				if err != nil {
+					log.Printf("[Mode: %s] Context routing: rejecting request (~%d tokens)", mode, tokens)
+					return nil, err
+				}
+				if routed != newModel {
+					log.Printf("[Mode: %s] Context routing: ~%d tokens -> %s", mode, tokens, routed)
+					newModel = routed
+				}
+			}
+
+			if res.variant != "" && res.variant != newModel {
+				// Overridden by agent/context routing: not part of the split
+				res.experiment, res.variant = "", ""
+			}
+
 			if newModel != originalModel {
 				data["model"] = newModel
 				log.Printf("[Mode: %s] Rewriting model: %s -> %s", mode, originalModel, newModel)
+
+				// Step 4: Parameter rewriting (mode-wide first, then the matched mapping)
+				changes := applyParamTransform(data, modeConfig.Transform)
+				if groupMapping != nil {
+					changes = append(changes, applyParamTransform(data, groupMapping.Transform)...)
+				} else if m := findMapping(originalModel, modeConfig); m != nil && (m.Rewrite == newModel || res.variant == newModel) {
+					changes = append(changes, applyParamTransform(data, m.Transform)...)
+				}
+				if len(changes) > 0 {
+					log.Printf("[Mode: %s] Transform for %s: %s", mode, newModel, strings.Join(changes, ", "))
+				}
 			}
+			res.model = newModel
 		}
 	}
 
-	// Strip thinking blocks for non-claude modes (Gemini doesn't support them)
-	if mode != "claude" {
-		if messages, ok := data["messages"].([]interface{}); ok {
-			stripped := stripThinkingBlocks(messages)
-			data["messages"] = stripped
-		}
+	// Strip constructs the backend doesn't support (thinking blocks for Gemini by default)
+	if stats := sanitizeRequest(data, modeConfig, mode); stats != nil {
+		res.sanitized = stats
+		log.Printf("[Mode: %s] Sanitized: %s", mode, stats)
 	}
 
-	return json.Marshal(data)
+	out, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	res.body = out
+	return res, nil
 }
 
 type loggingResponseWriter struct {
@@ -162,23 +237,40 @@ func newLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
 	return &loggingResponseWriter{w, http.StatusOK}
 }
 
+// StatusCode returns the status written to the client.
+func (lrw *loggingResponseWriter) StatusCode() int {
+	return lrw.statusCode
+}
+
+// statusResponseWriter is a ResponseWriter that remembers its status code.
+type statusResponseWriter interface {
+	http.ResponseWriter
+	StatusCode() int
+}
+
 // switchableResponseWriter starts in "deciding" mode. On WriteHeader:
 // - If status >= 400: switch to buffer mode (capture body for potential retry)
 // - If status < 400: switch to passthrough mode (write directly to real writer)
 // This allows error responses to be buffered for retry while successful
 // streaming responses pass through immediately.
+//
+// With holdStreams set, a successful SSE response is held back instead
+// (holding mode) until Commit, so a stream that fails before producing
+// content can still be retried.
 type switchableResponseWriter struct {
-	real       http.ResponseWriter
-	statusCode int
-	header     http.Header
-	body       bytes.Buffer
-	mode       int // 0=deciding, 1=buffering, 2=passthrough
+	real        http.ResponseWriter
+	statusCode  int
+	header      http.Header
+	body        bytes.Buffer
+	mode        int // 0=deciding, 1=buffering, 2=passthrough, 3=holding
+	holdStreams bool
 }
 
 const (
 	modeDeciding    = 0
 	modeBuffering   = 1
 	modePassthrough = 2
+	modeHolding     = 3
 )
 
 func newSwitchableResponseWriter(w http.ResponseWriter) *switchableResponseWriter {
@@ -203,6 +295,9 @@ func (s *switchableResponseWriter) WriteHeader(code int) {
 		if code >= 400 {
 			// Error response: buffer it for potential retry
 			s.mode = modeBuffering
+		} else if s.holdStreams && isSSEHeader(s.header) {
+			// Stream: hold until content flows (see Commit)
+			s.mode = modeHolding
 		} else {
 			// Success: passthrough mode, copy headers and write
 			s.mode = modePassthrough
@@ -227,7 +322,7 @@ func (s *switchableResponseWriter) Write(data []byte) (int, error) {
 	if s.mode == modePassthrough {
 		return s.real.Write(data)
 	}
-	// Buffering mode
+	// Buffering or holding mode
 	return s.body.Write(data)
 }
 
@@ -246,6 +341,29 @@ func (s *switchableResponseWriter) IsBuffered() bool {
 	return s.mode == modeBuffering
 }
 
+// IsHolding returns true if a successful stream is still held back (nothing
+// has been sent to the client yet).
+func (s *switchableResponseWriter) IsHolding() bool {
+	return s.mode == modeHolding
+}
+
+// Commit sends a held-back stream to the client and switches to passthrough.
+func (s *switchableResponseWriter) Commit() {
+	if s.mode != modeHolding {
+		return
+	}
+	for k, v := range s.header {
+		for _, vv := range v {
+			s.real.Header().Add(k, vv)
+		}
+	}
+	s.real.WriteHeader(s.statusCode)
+	s.real.Write(s.body.Bytes())
+	s.body.Reset()
+	s.mode = modePassthrough
+	s.Flush()
+}
+
 // StatusCode returns the captured status code
 func (s *switchableResponseWriter) StatusCode() int {
 	return s.statusCode
@@ -270,15 +388,50 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%.1fs", d.Seconds())
 }
 
-func createReverseProxy(upstream string) *httputil.ReverseProxy {
-	target, _ := url.Parse(upstream)
+func createReverseProxy(cfg *UpstreamConfig) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
 	proxy := httputil.NewSingleHostReverseProxy(target)
 	proxy.FlushInterval = -1 // Enable streaming for SSE
 
+	transport, err := newUpstreamTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		proxy.Transport = transport
+	}
+
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
 		req.Host = target.Host
+
+		// Let the transport negotiate gzip itself so response hooks see a
+		// decoded body (the client hop is local, so this costs nothing).
+		req.Header.Del("Accept-Encoding")
+
+		for k, v := range cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		if mode, ok := attemptMode(req); ok {
+			applyHeaderRules(req.Header, appConfig.Modes[mode].Headers)
+		}
+	}
+
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		info, ok := resp.Request.Context().Value(routeInfoKey).(*routeInfo)
+		if !ok {
+			// Sent as is by serveDirect: no model rewrite, nothing to tap
+			if mode, ok := resp.Request.Context().Value(targetKey).(string); ok {
+				setRoutingHeaders(resp, mode, "")
+			}
+			return nil
+		}
+		setRoutingHeaders(resp, info.mode, info.model)
+		return buildResponseTap(info, resp.StatusCode).apply(resp)
 	}
 
 	// Custom error handler: distinguishes timeouts (504) from connection errors (502)
@@ -301,31 +454,100 @@ func createReverseProxy(upstream string) *httputil.ReverseProxy {
 		}
 	}
 
-	return proxy
+	return proxy, nil
 }
 
-func proxyHandler(proxy *httputil.ReverseProxy) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		reqNum := requestCount.Add(1)
-		intent := configWatcher.GetMode()
-
-		// Resolve "auto" -> concrete target
-		target := autoSwitch.resolveRouting(intent)
+// prepareAttempt rewrites bodyBytes for target and returns a copy of r that
+// carries the new body plus fresh per-attempt state (proxyResult, routeInfo).
+func prepareAttempt(r *http.Request, bodyBytes []byte, target, sessKey string) (*http.Request, *proxyResult, error) {
+	mc, ok := appConfig.Modes[target]
+	var modeConfig *ModeConfig
+	if ok {
+		modeConfig = &mc
+	}
 
-		if intent == "auto" {
-			log.Printf("[Req #%d] %s %s (mode: auto, target: %s)", reqNum, r.Method, r.URL.Path, target)
-		} else {
-			log.Printf("[Req #%d] %s %s (mode: %s)", reqNum, r.Method, r.URL.Path, target)
+	info := &routeInfo{mode: target, usage: &tokenUsage{}, stream: &streamMonitor{}}
+	body := bodyBytes
+	if len(bodyBytes) > 0 {
+		res, err := rewriteRequest(bodyBytes, modeConfig, target, sessKey, r.Header)
+		if err != nil {
+			return nil, nil, err
 		}
+		body = res.body
+		sanitizeTotals.add(res.sanitized)
+		info.originalModel = res.originalModel
+		info.model = res.model
+		info.experiment = res.experiment
+		info.variant = res.variant
+		info.agent, info.agentGroup = res.agent, res.agentGroup
+	}
+	if ar, ok := r.Context().Value(agentKey).(agentRoute); ok && info.agent == "" {
+		info.agent, info.agentGroup = ar.agent, ar.group
+	}
+	if modeConfig != nil {
+		info.restoreModel = modeConfig.RestoreResponseModel
+	}
+	info.backend = backendFor(target, modeConfig)
+
+	result := &proxyResult{}
+	ctx := context.WithValue(r.Context(), proxyResultKey, result)
+	ctx = context.WithValue(ctx, routeInfoKey, info)
+	attempt := r.WithContext(ctx)
+	attempt.Body = io.NopCloser(bytes.NewReader(body))
+	attempt.ContentLength = int64(len(body))
+	return attempt, result, nil
+}
+
+// serveAttempt forwards a prepared attempt to target's upstream, records
+// per-variant and per-agent stats and returns the outcome.
+func serveAttempt(upstreams *upstreamSet, target string, w statusResponseWriter, r *http.Request, result *proxyResult) attemptOutcome {
+	start := time.Now()
+	upstreams.forMode(target).serve(w, r, result)
+
+	result.mu.Lock()
+	out := attemptOutcome{status: w.StatusCode(), failed: result.err != nil, elapsed: time.Since(start)}
+	result.mu.Unlock()
+
+	info, _ := r.Context().Value(routeInfoKey).(*routeInfo)
+	if info == nil {
+		return out
+	}
+	out.inputTokens, out.outputTokens = info.usage.input, info.usage.output
+	out.streamError = info.stream.failure()
+	if info.variant != "" && !info.shadow {
+		variantStats.observe(out, info.mode, info.experiment, info.variant)
+	}
+	if info.agent != "" && !info.shadow {
+		observeAgent(out, info)
+	}
+	return out
+}
 
-		// Look up mode config using resolved target (not intent)
-		mc, ok := appConfig.Modes[target]
-		var modeConfig *ModeConfig
-		if ok {
-			modeConfig = &mc
+func proxyHandler(upstreams *upstreamSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqNum := requestCount.Add(1)
+		prof := profileFor(r)
+		intent := prof.mode()
+		auto := prof.auto
+
+		route := matchRoute(appConfig.Routes, r.URL.Path)
+		switch route.Action {
+		case routeBlock:
+			log.Printf("[Req #%d] %s %s blocked", reqNum, r.Method, r.URL.Path)
+			writeAnthropicError(w, http.StatusNotFound, "not_found_error", "endpoint not available through rrouter")
+			return
+		case routePassthrough:
+			if mode, _ := requestModeOverride(appConfig.ModeOverride, r, nil, reqNum); mode != "" {
+				intent = mode
+			}
+			target := auto.resolveRouting(intent)
+			log.Printf("[Req #%d] %s %s (passthrough to %s)", reqNum, r.Method, r.URL.Path, target)
+			r = r.WithContext(context.WithValue(r.Context(), intentKey, intent))
+			serveDirect(upstreams, w, r, target, reqNum, "passthrough")
+			return
 		}
 
-		// Read and modify request body
+		// Read request body once; each attempt rewrites it for its own target
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
 			log.Printf("[Req #%d] Error reading body: %v", reqNum, err)
@@ -334,33 +556,127 @@ func proxyHandler(proxy *httputil.ReverseProxy) http.HandlerFunc {
 		}
 		r.Body.Close()
 
-		var modifiedBody []byte
+		// Per-request mode (header or model suffix) replaces the global one
+		if mode, body := requestModeOverride(appConfig.ModeOverride, r, bodyBytes, reqNum); mode != "" {
+			intent, bodyBytes = mode, body
+		}
+		r = r.WithContext(context.WithValue(r.Context(), intentKey, intent))
+
+		// Session key: used for auto-mode pins and sticky A/B splits
+		var sessKey string
 		if len(bodyBytes) > 0 {
-			modifiedBody, err = modifyRequestBody(bodyBytes, modeConfig, target)
+			sessCfg := appConfig.Sessions
+			if sessCfg == nil {
+				sessCfg = &SessionConfig{}
+			}
+			var reqData map[string]interface{}
+			json.Unmarshal(bodyBytes, &reqData)
+			sessKey = sessionKey(sessCfg, r, reqData)
+		}
+
+		// Resolve "auto" -> concrete target (session pin first, if enabled)
+		target := auto.resolveRouting(intent)
+		if intent == "auto" && sessionPins != nil {
+			target = sessionPins.resolve(prof.watcher.profile, sessKey, target, auto.isFailing)
+		}
+
+		// Agent group with a target mode: the whole request moves there.
+		// Auto failover still works from autoTarget: the group's mode is not
+		// an auto target, so its results only count if it is the current one.
+		autoTarget := target
+		if agent, g := agentModeFor(bodyBytes, r.Header, target); g != nil {
+			log.Printf("[Req #%d] Agent routing: %s (%s) -> mode %s", reqNum, agent, g.Name, g.Mode)
+			target = g.Mode
+			r = r.WithContext(context.WithValue(r.Context(), agentKey, agentRoute{agent, g.label()}))
+		}
+
+		if intent == "auto" {
+			log.Printf("[Req #%d] %s %s (mode: auto, target: %s)", reqNum, r.Method, r.URL.Path, target)
+		} else {
+			log.Printf("[Req #%d] %s %s (mode: %s)", reqNum, r.Method, r.URL.Path, target)
+		}
+
+		if len(bodyBytes) > 0 && !json.Valid(bodyBytes) {
+			// Nothing to rewrite; don't turn it into a 400
+			serveDirect(upstreams, w, withBody(r, bodyBytes), target, reqNum, "non-JSON body")
+			return
+		}
+		if !route.countsForHealth(r.URL.Path) {
+			if isAuxiliaryPath(r.URL.Path) {
+				serveCountTokens(upstreams, w, r, bodyBytes, target, sessKey, reqNum)
+				return
+			}
+			attempt, _, err := prepareAttempt(r, bodyBytes, target, sessKey)
 			if err != nil {
 				log.Printf("[Req #%d] Error modifying body: %v", reqNum, err)
-				http.Error(w, "Error processing request", http.StatusBadRequest)
+				writeRequestError(w, err)
 				return
 			}
-			r.Body = io.NopCloser(bytes.NewReader(modifiedBody))
-			r.ContentLength = int64(len(modifiedBody))
-		} else {
-			modifiedBody = bodyBytes
-			r.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+			serveDirect(upstreams, w, attempt, target, reqNum, "not health-counted")
+			return
 		}
 
-		// Set up per-request error tracking via context
-		result := &proxyResult{}
-		ctx := context.WithValue(r.Context(), proxyResultKey, result)
-		r = r.WithContext(ctx)
+		r, result, err := prepareAttempt(r, bodyBytes, target, sessKey)
+		if err != nil {
+			log.Printf("[Req #%d] Error modifying body: %v", reqNum, err)
+			writeRequestError(w, err)
+			return
+		}
+
+		// Mirror a sample to the shadow mode; record the client-visible
+		// outcome alongside it
+		var primary attemptOutcome
+		primaryMode := target
+		if startShadow(upstreams, r, bodyBytes, target, sessKey, reqNum) {
+			defer func() { shadowStats.observe(primary, shadowRolePrimary, primaryMode) }()
+		}
+
+		// Hedged mapping: race the primary against a fallback mode (this
+		// replaces the auto-mode retry)
+		if hedge := hedgeFor(bodyBytes, target); hedge != nil {
+			startTime := time.Now()
+			legs, winner := serveHedged(upstreams, w, r, result, bodyBytes, target, sessKey, hedge, reqNum)
+			for _, leg := range legs {
+				if intent != "auto" {
+					break
+				}
+				if leg.out.failed {
+					auto.recordTargetResponse(leg.mode, true, 0, leg.result.isTimeout)
+				} else if leg.out.streamError != "" {
+					auto.recordStreamFailure(leg.mode, true, leg.out.streamError)
+				} else {
+					auto.recordTargetResponse(leg.mode, true, leg.out.status, false)
+				}
+			}
+			if winner != nil {
+				primary, primaryMode = winner.out, winner.mode
+				if intent == "auto" && sessionPins != nil && winner.mode != target {
+					sessionPins.pin(prof.watcher.profile, sessKey, winner.mode)
+				}
+			} else if len(legs) > 0 {
+				primary = legs[0].out
+			}
+			log.Printf("[Req #%d] Response: %d via %s (%s, hedged)", reqNum, primary.status, primaryMode, formatDuration(time.Since(startTime)))
+			return
+		}
 
 		// AUTO MODE with internal retry
 		if intent == "auto" {
 			startTime := time.Now()
+			// A pinned session may still be on the previous target; only
+			// its results on the current target drive switching
+			pinned := sessionPins != nil && sessKey != ""
+			moved := target != autoTarget
 
 			// Use switchable writer: buffers error responses, passes through success
 			sw := newSwitchableResponseWriter(w)
-			proxy.ServeHTTP(sw, r)
+			if appConfig.RetryStreamErrors {
+				// Hold streams until the first content delta so a stream that
+				// fails before any content can still be retried
+				sw.holdStreams = true
+				r.Context().Value(routeInfoKey).(*routeInfo).stream.onContent = sw.Commit
+			}
+			primary = serveAttempt(upstreams, target, sw, r, result)
 			elapsed := time.Since(startTime)
 
 			// Check if we got an error that was buffered
@@ -379,57 +695,59 @@ func proxyHandler(proxy *httputil.ReverseProxy) http.HandlerFunc {
 				// Error response was buffered
 				needsRetry = true
 				log.Printf("[Req #%d] Response: %d (%s)", reqNum, sw.StatusCode(), formatDuration(elapsed))
+			} else if primary.streamError != "" {
+				// 2xx stream that failed part-way: retry only if nothing
+				// has reached the client yet
+				log.Printf("[Req #%d] Response: %d, stream failed: %s (%s)", reqNum, sw.StatusCode(), primary.streamError, formatDuration(elapsed))
+				if !sw.IsHolding() {
+					auto.recordStreamFailure(target, pinned || moved, primary.streamError)
+					return
+				}
+				needsRetry = true
 			} else {
 				// Success (already passed through to client)
+				sw.Commit()
 				log.Printf("[Req #%d] Response: %d (%s)", reqNum, sw.StatusCode(), formatDuration(elapsed))
-				autoSwitch.recordUpstreamResponse(sw.StatusCode(), false)
+				auto.recordTargetResponse(target, pinned || moved, sw.StatusCode(), false)
 				return
 			}
 
 			if needsRetry {
 				// Record failure for auto-switch state
 				if resultErr != nil {
-					autoSwitch.recordUpstreamResponse(0, resultIsTimeout)
+					auto.recordTargetResponse(target, pinned || moved, 0, resultIsTimeout)
+				} else if primary.streamError != "" {
+					auto.recordStreamFailure(target, pinned || moved, primary.streamError)
 				} else {
-					autoSwitch.recordUpstreamResponse(sw.StatusCode(), false)
+					auto.recordTargetResponse(target, pinned || moved, sw.StatusCode(), false)
 				}
 
 				// Get fallback target
-				fallback := oppositeTarget(target)
+				fallback := oppositeTarget(autoTarget)
+				if fallback == target {
+					// Moved there by an agent group; fall back to the auto target
+					fallback = autoTarget
+				}
 				log.Printf("[AUTO-RETRY] %s failed, retrying on %s", target, fallback)
 
-				// Re-modify body for fallback target
-				fallbackMC, fbOK := appConfig.Modes[fallback]
-				var retryBody []byte
-				if len(bodyBytes) > 0 {
-					var fbModeConfig *ModeConfig
-					if fbOK {
-						fbModeConfig = &fallbackMC
-					}
-					retryBody, err = modifyRequestBody(bodyBytes, fbModeConfig, fallback)
-					if err != nil {
-						log.Printf("[AUTO-RETRY] Error modifying body for %s: %v", fallback, err)
-						// Fall back to original error response
+				// Re-modify body for fallback target, with fresh proxyResult
+				retryReq, retryResult, err := prepareAttempt(r, bodyBytes, fallback, sessKey)
+				if err != nil {
+					log.Printf("[AUTO-RETRY] Error modifying body for %s: %v", fallback, err)
+					// Fall back to original error response (or failed stream)
+					if sw.IsHolding() {
+						sw.Commit()
+					} else {
 						sw.WriteTo(w)
-						return
 					}
-				} else {
-					retryBody = bodyBytes
+					return
 				}
 
-				// Reset request body for retry
-				r.Body = io.NopCloser(bytes.NewReader(retryBody))
-				r.ContentLength = int64(len(retryBody))
-
-				// Fresh proxyResult for retry
-				retryResult := &proxyResult{}
-				retryCtx := context.WithValue(r.Context(), proxyResultKey, retryResult)
-				r = r.WithContext(retryCtx)
-
 				// Retry directly to client (no more buffering)
 				lrw := newLoggingResponseWriter(w)
 				retryStart := time.Now()
-				proxy.ServeHTTP(lrw, r)
+				primary = serveAttempt(upstreams, fallback, lrw, retryReq, retryResult)
+				primaryMode = fallback
 				retryElapsed := time.Since(retryStart)
 
 				// Record retry result
@@ -439,11 +757,18 @@ func proxyHandler(proxy *httputil.ReverseProxy) http.HandlerFunc {
 				retryResult.mu.Unlock()
 
 				if retryErr != nil {
-					autoSwitch.recordUpstreamResponse(0, retryIsTimeout)
+					auto.recordTargetResponse(fallback, pinned, 0, retryIsTimeout)
 					log.Printf("[AUTO-RETRY] Retry on %s: proxy error (%s)", fallback, formatDuration(retryElapsed))
+				} else if primary.streamError != "" {
+					auto.recordStreamFailure(fallback, pinned, primary.streamError)
+					log.Printf("[AUTO-RETRY] Retry on %s: HTTP %d, stream failed: %s (%s)", fallback, lrw.statusCode, primary.streamError, formatDuration(retryElapsed))
 				} else {
-					autoSwitch.recordUpstreamResponse(lrw.statusCode, false)
+					auto.recordTargetResponse(fallback, pinned, lrw.statusCode, false)
 					log.Printf("[AUTO-RETRY] Retry on %s: HTTP %d (%s)", fallback, lrw.statusCode, formatDuration(retryElapsed))
+					if sessionPins != nil && lrw.statusCode < 400 {
+						// Session continues where it last succeeded
+						sessionPins.pin(prof.watcher.profile, sessKey, fallback)
+					}
 				}
 				return
 			}
@@ -453,15 +778,20 @@ func proxyHandler(proxy *httputil.ReverseProxy) http.HandlerFunc {
 		// NON-AUTO MODE: existing behavior unchanged
 		lrw := newLoggingResponseWriter(w)
 		startTime := time.Now()
-		proxy.ServeHTTP(lrw, r)
+		primary = serveAttempt(upstreams, target, lrw, r, result)
 		elapsed := time.Since(startTime)
+		if primary.streamError != "" {
+			log.Printf("[Req #%d] Response: %d, stream failed: %s (%s)", reqNum, lrw.statusCode, primary.streamError, formatDuration(elapsed))
+			return
+		}
 		log.Printf("[Req #%d] Response: %d (%s)", reqNum, lrw.statusCode, formatDuration(elapsed))
 	}
 }
 
 func serveHealthHandler(w http.ResponseWriter, r *http.Request) {
-	intent := configWatcher.GetMode()
-	target := autoSwitch.resolveRouting(intent)
+	prof := profileFor(r)
+	intent := prof.mode()
+	target := prof.auto.resolveRouting(intent)
 
 	response := map[string]interface{}{
 		"status":        "ok",
@@ -472,13 +802,44 @@ func serveHealthHandler(w http.ResponseWriter, r *http.Request) {
 		"upstreamURL":   upstreamURL,
 		"defaultMode":   appConfig.DefaultMode,
 	}
+	if _, ok := r.Context().Value(profileKey).(*profile); ok {
+		response["profile"] = prof.name
+	} else {
+		if serveProfiles != nil && len(serveProfiles.byName) > 0 {
+			response["profiles"] = serveProfiles.HealthInfo()
+		}
+		if sched := serveSchedule.Load(); sched != nil {
+			response["schedule"] = sched.HealthInfo(time.Now(), intent)
+		}
+	}
+
+	for k, v := range serveUpstreams.HealthInfo() {
+		response[k] = v
+	}
+
+	if sanitized := sanitizeTotals.snapshot(); len(sanitized) > 0 {
+		response["sanitized"] = sanitized
+	}
+	response["thinkingSignatures"] = thinkingSignatures.size()
+	if variants := variantStats.HealthInfo(); len(variants) > 0 {
+		response["variants"] = variants
+	}
+	if shadow := shadowStats.HealthInfo(); len(shadow) > 0 {
+		response["shadow"] = shadow
+	}
+	if agents := agentHealthInfo(); agents != nil {
+		response["agents"] = agents
+	}
 
 	// Add auto-switch details when in auto mode
 	if intent == "auto" {
-		autoInfo := autoSwitch.HealthInfo()
+		autoInfo := prof.auto.HealthInfo()
 		for k, v := range autoInfo {
 			response[k] = v
 		}
+		if sessionPins != nil {
+			response["sessions"] = sessionPins.HealthInfo()
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -534,39 +895,67 @@ func cmdServe() {
 	listenAddr, upstreamURL = getConfig()
 	appConfig = loadConfigWithDefaults()
 
-	// Validate agent routing configs
+	// Validate per-mode configs
 	for modeName, modeConfig := range appConfig.Modes {
 		if modeConfig.AgentRouting != nil {
-			validateAgentRoutingConfig(modeConfig.AgentRouting, modeName)
+			validateAgentRoutingConfig(modeConfig.AgentRouting, modeName, appConfig.Modes)
 		}
+		validateMappings(modeConfig.Mappings, modeName)
+		validateSanitizeConfig(modeConfig.Sanitize, modeName)
+		validateShadowConfig(modeConfig.Shadow, modeName, appConfig)
+		validateCountTokens(modeConfig.CountTokens, modeName)
+		validateHeaderRules(modeConfig.Headers, modeName)
 	}
+	validateRoutes(appConfig.Routes)
+	validateModeOverride(appConfig.ModeOverride, appConfig)
+	validateProfiles(appConfig)
+	validateSchedule(appConfig)
 
 	autoSwitch = newAutoState(appConfig.DefaultMode)
+	if appConfig.Sessions != nil && appConfig.Sessions.Enabled {
+		sessionPins = newSessionTable(appConfig.Sessions)
+	}
 
 	// Initialize filesystem watcher for mode and config
 	homeDir, _ := os.UserHomeDir()
 	rrouterDir := filepath.Join(homeDir, ".rrouter")
 	configWatcher = newConfigWatcher(rrouterDir, appConfig)
 	defer configWatcher.Close()
+	serveProfiles = newProfileSet(appConfig, rrouterDir)
+	defer serveProfiles.Close()
+	sched, _ := newSchedule(appConfig.Schedule)
+	serveSchedule.Store(sched)
+	go runSchedule(filepath.Join(rrouterDir, "mode"))
 
 	// Write PID file (for launchd/systemd-started daemons)
 	writePIDFile()
 
-	proxy := createReverseProxy(upstreamURL)
+	upstreams, err := newUpstreamSet(appConfig, upstreamURL)
+	if err != nil {
+		log.Fatalf("Invalid upstream config: %v", err)
+	}
+	serveUpstreams = upstreams
 
 	http.HandleFunc("/health", serveHealthHandler)
-	http.HandleFunc("/", proxyHandler(proxy))
+	http.HandleFunc("/metrics", serveMetricsHandler)
+	proxy := proxyHandler(upstreams)
+	http.HandleFunc("/v1/chat/completions", openAIHandler(proxy))
+	http.HandleFunc("/v1/models", serveModelsHandler(upstreams))
+	http.HandleFunc("/", proxy)
 
 	log.Println("=======================================================")
 	log.Println("  rrouter started")
 	log.Printf("  Listen:  %s", listenAddr)
-	log.Printf("  Upstream: %s", upstreamURL)
+	upstreams.logUpstreams()
 	log.Printf("  Mode:    %s", configWatcher.GetMode())
 	log.Printf("  Modes:   %d loaded", len(appConfig.Modes))
+	for _, name := range sortedKeys(serveProfiles.byName) {
+		log.Printf("  Profile %s: mode %s", name, serveProfiles.byName[name].mode())
+	}
 	log.Println("=======================================================")
 
 	// Graceful shutdown on SIGTERM/SIGINT
-	srv := &http.Server{Addr: listenAddr}
+	srv := &http.Server{Addr: listenAddr, Handler: serveProfiles.wrap(http.DefaultServeMux)}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
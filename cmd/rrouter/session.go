@@ -0,0 +1,228 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session key sources, tried in the configured order.
+const (
+	sessionKeyHeader       = "header"        // value of SessionConfig.Header
+	sessionKeyMetadata     = "metadata"      // metadata.user_id (Claude Code embeds the session id)
+	sessionKeyFirstMessage = "first_message" // hash of the first user message
+)
+
+const (
+	defaultSessionHeader     = "X-RRouter-Session"
+	defaultSessionMaxEntries = 1000
+	defaultSessionTTL        = 2 * time.Hour
+)
+
+// SessionConfig pins each conversation to the auto-mode target it started
+// on, so a target flip doesn't move an ongoing session to another model
+// family (breaking prompt caching and thinking continuity).
+type SessionConfig struct {
+	Enabled    bool     `json:"enabled"`
+	KeySources []string `json:"keySources,omitempty"` // default: header, metadata, first_message
+	Header     string   `json:"header,omitempty"`     // default: X-RRouter-Session
+	MaxEntries int      `json:"maxEntries,omitempty"` // LRU capacity, default 1000
+	TTL        string   `json:"ttl,omitempty"`        // idle expiry (Go duration), default 2h
+}
+
+// sessionKey derives a stable key for the conversation a request belongs
+// to, or "" if none of the configured sources yields one. Keys are hashed so
+// user identifiers and prompt text are never held in memory.
+func sessionKey(cfg *SessionConfig, r *http.Request, data map[string]interface{}) string {
+	sources := cfg.KeySources
+	if len(sources) == 0 {
+		sources = []string{sessionKeyHeader, sessionKeyMetadata, sessionKeyFirstMessage}
+	}
+	for _, src := range sources {
+		var raw string
+		switch src {
+		case sessionKeyHeader:
+			header := cfg.Header
+			if header == "" {
+				header = defaultSessionHeader
+			}
+			raw = r.Header.Get(header)
+		case sessionKeyMetadata:
+			if meta, ok := data["metadata"].(map[string]interface{}); ok {
+				raw, _ = meta["user_id"].(string)
+			}
+		case sessionKeyFirstMessage:
+			raw = firstUserMessage(data)
+		}
+		if raw != "" {
+			sum := sha256.Sum256([]byte(src + ":" + raw))
+			return src + ":" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return ""
+}
+
+// firstUserMessage returns the serialized content of the first user message.
+func firstUserMessage(data map[string]interface{}) string {
+	messages, _ := data["messages"].([]interface{})
+	for _, msg := range messages {
+		msgMap, ok := msg.(map[string]interface{})
+		if !ok || msgMap["role"] != "user" {
+			continue
+		}
+		if s, ok := msgMap["content"].(string); ok {
+			return s
+		}
+		b, err := json.Marshal(msgMap["content"])
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	}
+	return ""
+}
+
+// sessionPinKey scopes a session to its profile ("" for ~/.rrouter/mode),
+// so each profile's mode switches clear only its own pins.
+type sessionPinKey struct {
+	profile, key string
+}
+
+type sessionPin struct {
+	id       sessionPinKey
+	target   string
+	pinnedAt time.Time
+	lastUsed time.Time
+}
+
+// sessionTable is a bounded LRU of session -> target pins.
+type sessionTable struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[sessionPinKey]*list.Element
+	lru        *list.List // front = most recently used
+	now        func() time.Time
+}
+
+func newSessionTable(cfg *SessionConfig) *sessionTable {
+	maxEntries := cfg.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultSessionMaxEntries
+	}
+	ttl := defaultSessionTTL
+	if cfg.TTL != "" {
+		if d, err := time.ParseDuration(cfg.TTL); err == nil && d > 0 {
+			ttl = d
+		} else {
+			log.Printf("[WARN] Invalid sessions.ttl %q, using %s", cfg.TTL, defaultSessionTTL)
+		}
+	}
+	return &sessionTable{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[sessionPinKey]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// resolve returns the target for a session. An unpinned (or expired)
+// session is pinned to current. A pinned session keeps its target unless
+// isFailing reports that target as unhealthy, in which case it is re-pinned.
+func (t *sessionTable) resolve(profile, key, current string, isFailing func(string) bool) string {
+	if key == "" {
+		return current
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := sessionPinKey{profile, key}
+	now := t.now()
+	if el, ok := t.entries[id]; ok {
+		pin := el.Value.(*sessionPin)
+		if now.Sub(pin.lastUsed) <= t.ttl {
+			if pin.target == current || !isFailing(pin.target) {
+				pin.lastUsed = now
+				t.lru.MoveToFront(el)
+				return pin.target
+			}
+			log.Printf("[SESSION] %s: pinned target %s is failing, re-pinning to %s", key, pin.target, current)
+		}
+		t.removeLocked(el)
+	}
+	t.pinLocked(id, current, now)
+	return current
+}
+
+// pin sets (or moves) a session's target, e.g. after a successful failover.
+func (t *sessionTable) pin(profile, key, target string) {
+	if key == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := sessionPinKey{profile, key}
+	if el, ok := t.entries[id]; ok {
+		pin := el.Value.(*sessionPin)
+		if pin.target != target {
+			log.Printf("[SESSION] %s: re-pinning %s -> %s", key, pin.target, target)
+		}
+		t.removeLocked(el)
+	}
+	t.pinLocked(id, target, t.now())
+}
+
+func (t *sessionTable) pinLocked(id sessionPinKey, target string, now time.Time) {
+	t.entries[id] = t.lru.PushFront(&sessionPin{id: id, target: target, pinnedAt: now, lastUsed: now})
+	for t.lru.Len() > t.maxEntries {
+		t.removeLocked(t.lru.Back())
+	}
+}
+
+func (t *sessionTable) removeLocked(el *list.Element) {
+	delete(t.entries, el.Value.(*sessionPin).id)
+	t.lru.Remove(el)
+}
+
+// clear drops a profile's pins (on manual mode switch, like autoState.reset).
+func (t *sessionTable) clear(profile string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for el := t.lru.Front(); el != nil; {
+		next := el.Next()
+		if el.Value.(*sessionPin).id.profile == profile {
+			t.removeLocked(el)
+		}
+		el = next
+	}
+}
+
+// HealthInfo returns session pin stats for the /health endpoint.
+func (t *sessionTable) HealthInfo() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	byTarget := make(map[string]int)
+	now := t.now()
+	active := 0
+	for el := t.lru.Front(); el != nil; el = el.Next() {
+		pin := el.Value.(*sessionPin)
+		if now.Sub(pin.lastUsed) > t.ttl {
+			continue
+		}
+		active++
+		byTarget[pin.target]++
+	}
+	return map[string]interface{}{
+		"pinned":     active,
+		"maxEntries": t.maxEntries,
+		"ttl":        t.ttl.String(),
+		"byTarget":   byTarget,
+	}
+}
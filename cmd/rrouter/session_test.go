@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionKey(t *testing.T) {
+	data := mustParse(t, `{
+		"metadata": {"user_id": "user_abc_account__session_123"},
+		"messages": [{"role": "user", "content": "hello"}]
+	}`)
+	noMeta := mustParse(t, `{"messages": [{"role": "user", "content": [{"type": "text", "text": "hello"}]}]}`)
+
+	tests := []struct {
+		name       string
+		cfg        *SessionConfig
+		header     string
+		data       map[string]interface{}
+		wantPrefix string
+	}{
+		{"header wins by default", &SessionConfig{}, "abc", data, "header:"},
+		{"metadata when no header", &SessionConfig{}, "", data, "metadata:"},
+		{"first message fallback", &SessionConfig{}, "", noMeta, "first_message:"},
+		{"configured order", &SessionConfig{KeySources: []string{"first_message", "metadata"}}, "", data, "first_message:"},
+		{"custom header", &SessionConfig{Header: "X-Conv"}, "", data, "metadata:"},
+		{"no sources match", &SessionConfig{KeySources: []string{"header"}}, "", data, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/messages", nil)
+			if tt.header != "" {
+				r.Header.Set(defaultSessionHeader, tt.header)
+			}
+			got := sessionKey(tt.cfg, r, tt.data)
+			if tt.wantPrefix == "" {
+				if got != "" {
+					t.Errorf("sessionKey() = %q, want empty", got)
+				}
+				return
+			}
+			if !strings.HasPrefix(got, tt.wantPrefix) {
+				t.Errorf("sessionKey() = %q, want prefix %q", got, tt.wantPrefix)
+			}
+			if strings.Contains(got, "session_123") || strings.Contains(got, "hello") {
+				t.Errorf("sessionKey() must be hashed, got %q", got)
+			}
+		})
+	}
+
+	r := httptest.NewRequest("POST", "/v1/messages", nil)
+	if sessionKey(&SessionConfig{}, r, data) != sessionKey(&SessionConfig{}, r, data) {
+		t.Error("sessionKey() must be stable")
+	}
+}
+
+func TestSessionTable_PinsAndKeepsTarget(t *testing.T) {
+	tbl := newSessionTable(&SessionConfig{})
+	never := func(string) bool { return false }
+
+	if got := tbl.resolve("", "s1", "antigravity", never); got != "antigravity" {
+		t.Fatalf("first resolve = %q, want antigravity", got)
+	}
+	// Auto mode flipped to claude; the session stays where it started
+	if got := tbl.resolve("", "s1", "claude", never); got != "antigravity" {
+		t.Errorf("pinned session moved to %q, want antigravity", got)
+	}
+	// New sessions start on the current target
+	if got := tbl.resolve("", "s2", "claude", never); got != "claude" {
+		t.Errorf("new session = %q, want claude", got)
+	}
+	if got := tbl.resolve("", "", "claude", never); got != "claude" {
+		t.Errorf("keyless request = %q, want claude", got)
+	}
+}
+
+func TestSessionTable_RepinsWhenTargetFailing(t *testing.T) {
+	tbl := newSessionTable(&SessionConfig{})
+	tbl.resolve("", "s1", "antigravity", func(string) bool { return false })
+
+	failing := func(target string) bool { return target == "antigravity" }
+	if got := tbl.resolve("", "s1", "claude", failing); got != "claude" {
+		t.Errorf("session on failing target = %q, want claude", got)
+	}
+	if got := tbl.resolve("", "s1", "antigravity", func(string) bool { return false }); got != "claude" {
+		t.Errorf("re-pinned session = %q, want claude", got)
+	}
+}
+
+func TestSessionTable_LRUEviction(t *testing.T) {
+	tbl := newSessionTable(&SessionConfig{MaxEntries: 2})
+	never := func(string) bool { return false }
+
+	tbl.resolve("", "a", "antigravity", never)
+	tbl.resolve("", "b", "antigravity", never)
+	tbl.resolve("", "a", "claude", never) // touch a, b is now least recent
+	tbl.resolve("", "c", "claude", never) // evicts b
+
+	if got := tbl.resolve("", "a", "claude", never); got != "antigravity" {
+		t.Errorf("recently used session evicted: got %q", got)
+	}
+	if got := tbl.resolve("", "b", "claude", never); got != "claude" {
+		t.Errorf("evicted session should be re-pinned to current, got %q", got)
+	}
+}
+
+func TestSessionTable_TTLExpiry(t *testing.T) {
+	tbl := newSessionTable(&SessionConfig{TTL: "1m"})
+	now := time.Now()
+	tbl.now = func() time.Time { return now }
+	never := func(string) bool { return false }
+
+	tbl.resolve("", "s1", "antigravity", never)
+	now = now.Add(2 * time.Minute)
+
+	if got := tbl.resolve("", "s1", "claude", never); got != "claude" {
+		t.Errorf("expired pin should be replaced, got %q", got)
+	}
+}
+
+func TestSessionTable_HealthInfo(t *testing.T) {
+	tbl := newSessionTable(&SessionConfig{MaxEntries: 10})
+	never := func(string) bool { return false }
+	tbl.resolve("", "a", "antigravity", never)
+	tbl.resolve("", "b", "claude", never)
+	tbl.pin("", "c", "claude")
+
+	info := tbl.HealthInfo()
+	if info["pinned"] != 3 {
+		t.Errorf("pinned = %v, want 3", info["pinned"])
+	}
+	byTarget := info["byTarget"].(map[string]int)
+	if byTarget["claude"] != 2 || byTarget["antigravity"] != 1 {
+		t.Errorf("byTarget = %v", byTarget)
+	}
+
+	tbl.clear("")
+	if tbl.HealthInfo()["pinned"] != 0 {
+		t.Error("clear() should drop all pins")
+	}
+}
+
+func TestSessionTable_ClearProfile(t *testing.T) {
+	tbl := newSessionTable(&SessionConfig{MaxEntries: 10})
+	never := func(string) bool { return false }
+	tbl.resolve("", "s1", "antigravity", never)
+	tbl.resolve("work", "s1", "claude", never)
+	tbl.resolve("work", "s2", "claude", never)
+
+	tbl.clear("work")
+	if info := tbl.HealthInfo(); info["pinned"] != 1 {
+		t.Errorf("pinned after clearing work = %v, want 1", info["pinned"])
+	}
+	// The default profile's pin survives; work's session starts over
+	if got := tbl.resolve("", "s1", "claude", never); got != "antigravity" {
+		t.Errorf("default pin = %q, want antigravity", got)
+	}
+	if got := tbl.resolve("work", "s1", "antigravity", never); got != "antigravity" {
+		t.Errorf("cleared work pin = %q, want antigravity", got)
+	}
+}
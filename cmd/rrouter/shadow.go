@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"net/http"
+)
+
+// maxShadowInflight bounds concurrent shadow requests; samples beyond it are
+// skipped rather than queued.
+const maxShadowInflight = 16
+
+const (
+	shadowRolePrimary = "primary"
+	shadowRoleShadow  = "shadow"
+)
+
+// ShadowConfig mirrors a sampled fraction of a mode's requests to a
+// candidate mode. Shadow responses never reach the client.
+type ShadowConfig struct {
+	Mode     string  `json:"mode"`
+	Fraction float64 `json:"fraction"` // 0..1
+}
+
+var (
+	shadowStats = newStatsTable("rrouter_shadow", "role", "mode")
+	shadowSlots = make(chan struct{}, maxShadowInflight)
+)
+
+// validateShadowConfig logs warnings for unusable shadow settings.
+func validateShadowConfig(sc *ShadowConfig, modeName string, cfg *Config) {
+	if sc == nil {
+		return
+	}
+	if _, ok := cfg.Modes[sc.Mode]; !ok || sc.Mode == modeName {
+		log.Printf("[WARN] Mode %q: shadow.mode %q must be another configured mode", modeName, sc.Mode)
+	}
+	if sc.Fraction <= 0 || sc.Fraction > 1 {
+		log.Printf("[WARN] Mode %q: shadow.fraction %v outside (0, 1], shadowing is effectively off or total", modeName, sc.Fraction)
+	}
+}
+
+// discardResponseWriter swallows a shadow response, keeping only its status.
+type discardResponseWriter struct {
+	header     http.Header
+	statusCode int
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: make(http.Header), statusCode: http.StatusOK}
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) WriteHeader(code int)        { d.statusCode = code }
+func (d *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponseWriter) Flush()                      {}
+func (d *discardResponseWriter) StatusCode() int             { return d.statusCode }
+
+// startShadow mirrors the request to the primary mode's shadow mode when it
+// is sampled, in the background. It reports whether a shadow was started;
+// the caller then records the primary outcome in shadowStats.
+func startShadow(upstreams *upstreamSet, r *http.Request, bodyBytes []byte, primary, sessKey string, reqNum uint64) bool {
+	mc, ok := appConfig.Modes[primary]
+	if !ok || mc.Shadow == nil || mc.Shadow.Mode == "" || mc.Shadow.Mode == primary {
+		return false
+	}
+	if rand.Float64() >= mc.Shadow.Fraction {
+		return false
+	}
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		log.Printf("[SHADOW] Req #%d: %d shadow requests in flight, skipping", reqNum, maxShadowInflight)
+		return false
+	}
+
+	shadowMode := mc.Shadow.Mode
+	// Outlive the client request: the primary may finish first
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	go func() {
+		defer func() { <-shadowSlots }()
+		defer func() {
+			// A body copy failing mid-response panics with ErrAbortHandler
+			// (req keeps the server context); nothing above us recovers it
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					panic(v)
+				}
+				shadowStats.observe(attemptOutcome{failed: true}, shadowRoleShadow, shadowMode)
+				log.Printf("[SHADOW] Req #%d -> %s: response aborted", reqNum, shadowMode)
+			}
+		}()
+
+		attempt, result, err := prepareAttempt(req, bodyBytes, shadowMode, sessKey)
+		if err != nil {
+			log.Printf("[SHADOW] Req #%d -> %s: %v", reqNum, shadowMode, err)
+			return
+		}
+		attempt.Context().Value(routeInfoKey).(*routeInfo).shadow = true
+
+		out := serveAttempt(upstreams, shadowMode, newDiscardResponseWriter(), attempt, result)
+		shadowStats.observe(out, shadowRoleShadow, shadowMode)
+		if out.failed {
+			log.Printf("[SHADOW] Req #%d -> %s: proxy error (%s)", reqNum, shadowMode, formatDuration(out.elapsed))
+			return
+		}
+		log.Printf("[SHADOW] Req #%d -> %s: %d (%s, tokens in/out: %d/%d)",
+			reqNum, shadowMode, out.status, formatDuration(out.elapsed), out.inputTokens, out.outputTokens)
+	}()
+	return true
+}
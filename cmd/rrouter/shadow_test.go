@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withAppConfig swaps in cfg as the global config for the duration of a test.
+func withAppConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	old := appConfig
+	appConfig = cfg
+	t.Cleanup(func() { appConfig = old })
+}
+
+func TestStartShadow_MirrorsAndDiscards(t *testing.T) {
+	received := make(chan string, 1)
+	shadowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"candidate","usage":{"input_tokens":12,"output_tokens":34}}`)
+		received <- string(body)
+	}))
+	defer shadowBackend.Close()
+
+	cfg := &Config{Modes: map[string]ModeConfig{
+		"primary": {Shadow: &ShadowConfig{Mode: "candidate", Fraction: 1}},
+		"candidate": {
+			Upstream: shadowBackend.URL,
+			Mappings: []ModelMapping{{Match: "claude-*", Rewrite: "candidate-model"}},
+		},
+	}}
+	withAppConfig(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/messages", strings.NewReader(""))
+	if !startShadow(upstreams, req, []byte(`{"model":"claude-sonnet-4-5"}`), "primary", "", 1) {
+		t.Fatal("fraction 1 should always shadow")
+	}
+
+	select {
+	case body := <-received:
+		if !strings.Contains(body, "candidate-model") {
+			t.Errorf("shadow body = %s, want rewritten for the shadow mode", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shadow request not received")
+	}
+
+	// Wait for the shadow goroutine to release its slot (after recording)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(shadowSlots) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	row, ok := shadowStats.HealthInfo()["shadow/candidate"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("shadow stats missing: %v", shadowStats.HealthInfo())
+	}
+	if row["inputTokens"].(int64) < 12 || row["outputTokens"].(int64) < 34 {
+		t.Errorf("shadow usage not recorded: %v", row)
+	}
+}
+
+func TestStartShadow_AbortedResponse(t *testing.T) {
+	shadowBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "truncated")
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler) // drop the connection mid-body
+	}))
+	defer shadowBackend.Close()
+
+	cfg := &Config{Modes: map[string]ModeConfig{
+		"primary":  {Shadow: &ShadowConfig{Mode: "aborting", Fraction: 1}},
+		"aborting": {Upstream: shadowBackend.URL},
+	}}
+	withAppConfig(t, cfg)
+	upstreams, err := newUpstreamSet(cfg, "http://127.0.0.1:1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// As served by net/http: ReverseProxy panics on copy errors
+	ctx := context.WithValue(context.Background(), http.ServerContextKey, &http.Server{})
+	req := httptest.NewRequest("POST", "/v1/messages", nil).WithContext(ctx)
+	if !startShadow(upstreams, req, []byte(`{"model":"claude-sonnet-4-5"}`), "primary", "", 1) {
+		t.Fatal("fraction 1 should always shadow")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for shadowStats.HealthInfo()["shadow/aborting"] == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	row, ok := shadowStats.HealthInfo()["shadow/aborting"].(map[string]interface{})
+	if !ok || row["errors"] != uint64(1) {
+		t.Errorf("aborted shadow not recorded as an error: %v", row)
+	}
+}
+
+func TestStartShadow_NotSampled(t *testing.T) {
+	withAppConfig(t, &Config{Modes: map[string]ModeConfig{
+		"primary":   {Shadow: &ShadowConfig{Mode: "candidate", Fraction: 0}},
+		"candidate": {},
+		"plain":     {},
+	}})
+
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	for _, mode := range []string{"primary", "plain", "unknown"} {
+		if startShadow(nil, req, nil, mode, "", 1) {
+			t.Errorf("mode %q should not be shadowed", mode)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Failure reasons for streams that ended (or broke) before message_stop.
+const (
+	streamTruncated = "truncated"
+	streamTimeout   = "stream_timeout" // first-byte or idle-stream timeout
+)
+
+// streamMonitor watches a successful SSE response for failures that arrive
+// after the 200 status: in-stream "event: error" payloads and streams that
+// end without message_stop. It runs on the reverse proxy's copy goroutine.
+type streamMonitor struct {
+	sawStop     bool
+	errType     string // error.type of an in-stream error event
+	truncated   bool
+	timedOut    bool
+	canceled    bool // the client went away; not an upstream failure
+	contentSent bool
+
+	// onContent is called before the first content_block_delta is passed
+	// on (used to stop holding a response back for a possible retry).
+	onContent func()
+}
+
+// hook is an sseHook recording the events that matter for failure detection.
+func (m *streamMonitor) hook(event string, data []byte) []byte {
+	if event == "" {
+		// Some upstreams omit "event:" lines; fall back to the payload type
+		var ev struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(data, &ev) == nil {
+			event = ev.Type
+		}
+	}
+	switch event {
+	case "message_stop":
+		m.sawStop = true
+	case "error":
+		var ev struct {
+			Error struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &ev)
+		m.errType = ev.Error.Type
+		if m.errType == "" {
+			m.errType = "error"
+		}
+	case "content_block_delta":
+		if !m.contentSent {
+			m.contentSent = true
+			if m.onContent != nil {
+				m.onContent()
+			}
+		}
+	}
+	return data
+}
+
+// end is called when the upstream body ends with err (io.EOF on a clean
+// close). A stream that stops before message_stop without having reported an
+// error gets a synthetic error event, so the client sees why it ended.
+func (m *streamMonitor) end(err error) []byte {
+	if errors.Is(err, context.Canceled) {
+		m.canceled = true
+		return nil
+	}
+	if m.sawStop || m.errType != "" {
+		return nil
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		m.timedOut = true
+	} else {
+		m.truncated = true
+	}
+	return []byte("event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"api_error\",\"message\":\"upstream stream ended unexpectedly\"}}\n\n")
+}
+
+// failure returns why the stream failed, or "" if it completed.
+func (m *streamMonitor) failure() string {
+	switch {
+	case m.canceled:
+		return ""
+	case m.errType != "":
+		return m.errType
+	case m.timedOut:
+		return streamTimeout
+	case m.truncated:
+		return streamTruncated
+	}
+	return ""
+}
+
+// streamFailureStatus maps a stream failure to the HTTP status it would have
+// had as a plain error response, for auto-mode accounting.
+func streamFailureStatus(reason string) int {
+	switch reason {
+	case "overloaded_error":
+		return 529
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case streamTruncated:
+		return http.StatusBadGateway
+	case streamTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// recordStreamFailure counts a failed stream against target in auto mode
+// (see recordTargetResponse); stream timeouts count as timeouts.
+func (s *autoState) recordStreamFailure(target string, onlyCurrent bool, reason string) {
+	if reason == streamTimeout {
+		s.recordTargetResponse(target, onlyCurrent, 0, true)
+		return
+	}
+	s.recordTargetResponse(target, onlyCurrent, streamFailureStatus(reason), false)
+}
+
+// sseEndHook is called once when an SSE body ends; its output is appended
+// to the stream. A non-EOF error is reported to the reader as io.EOF so the
+// reverse proxy finishes the response normally instead of aborting.
+type sseEndHook func(err error) []byte
+
+func (s *sseRewriter) finish(err error) {
+	if s.onEnd == nil {
+		s.err = err
+		return
+	}
+	s.out = append(s.out, s.onEnd(err)...)
+	s.err = io.EOF
+}
+
+// isSSEHeader reports whether h declares an event stream.
+func isSSEHeader(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "text/event-stream")
+}
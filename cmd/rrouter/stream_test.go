@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader returns data, then err.
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func (f *failingReader) Close() error { return nil }
+
+const (
+	testMessageStart = "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{}}\n\n"
+	testContentDelta = "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n"
+	testMessageStop  = "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+)
+
+func TestStreamMonitor(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          io.ReadCloser
+		wantFailure   string
+		wantSynthetic bool
+	}{
+		{
+			name: "complete stream",
+			body: &chunkedReader{data: testMessageStart + testContentDelta + testMessageStop, chunk: 9},
+		},
+		{
+			name:        "in-stream error event",
+			body:        &chunkedReader{data: testMessageStart + "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n", chunk: 9},
+			wantFailure: "overloaded_error",
+		},
+		{
+			name:        "error event without event line",
+			body:        &chunkedReader{data: "data: {\"type\":\"error\",\"error\":{\"type\":\"api_error\"}}\n\n", chunk: 9},
+			wantFailure: "api_error",
+		},
+		{
+			name:          "clean close before message_stop",
+			body:          &chunkedReader{data: testMessageStart + testContentDelta, chunk: 9},
+			wantFailure:   streamTruncated,
+			wantSynthetic: true,
+		},
+		{
+			name:          "connection dropped",
+			body:          &failingReader{data: testMessageStart, err: io.ErrUnexpectedEOF},
+			wantFailure:   streamTruncated,
+			wantSynthetic: true,
+		},
+		{
+			name:          "idle stream timeout",
+			body:          &failingReader{data: testMessageStart, err: &upstreamTimeoutError{what: "idle-stream", d: time.Second}},
+			wantFailure:   streamTimeout,
+			wantSynthetic: true,
+		},
+		{
+			name: "client went away",
+			body: &failingReader{data: testMessageStart, err: context.Canceled},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &routeInfo{stream: &streamMonitor{}}
+			resp := &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+				Body:       tt.body,
+			}
+			if err := buildResponseTap(info, 200).apply(resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			out, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("read errors must be swallowed, got %v", err)
+			}
+			if got := info.stream.failure(); got != tt.wantFailure {
+				t.Errorf("failure() = %q, want %q", got, tt.wantFailure)
+			}
+			if got := strings.Contains(string(out), "stream ended unexpectedly"); got != tt.wantSynthetic {
+				t.Errorf("synthetic error event = %v, want %v\n%s", got, tt.wantSynthetic, out)
+			}
+		})
+	}
+}
+
+func TestStreamMonitor_OnContent(t *testing.T) {
+	calls := 0
+	m := &streamMonitor{onContent: func() { calls++ }}
+	r := newSSERewriter(&chunkedReader{data: testMessageStart + testContentDelta + testContentDelta + testMessageStop, chunk: 4}, m.hook)
+	io.ReadAll(r)
+	if calls != 1 {
+		t.Errorf("onContent called %d times, want 1", calls)
+	}
+}
+
+func TestSwitchableResponseWriter_HoldsStreams(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := newSwitchableResponseWriter(rec)
+	sw.holdStreams = true
+	sw.Header().Set("Content-Type", "text/event-stream")
+	sw.WriteHeader(200)
+	sw.Write([]byte(testMessageStart))
+	sw.Flush()
+
+	if !sw.IsHolding() || rec.Body.Len() != 0 || rec.Flushed {
+		t.Fatalf("stream should be held: holding=%v body=%q", sw.IsHolding(), rec.Body.String())
+	}
+
+	sw.Commit()
+	sw.Write([]byte(testContentDelta))
+	if sw.IsHolding() || rec.Body.String() != testMessageStart+testContentDelta {
+		t.Errorf("after Commit: holding=%v body=%q", sw.IsHolding(), rec.Body.String())
+	}
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Errorf("headers not copied on Commit: %v", rec.Header())
+	}
+
+	// Non-stream successes still pass straight through
+	rec = httptest.NewRecorder()
+	sw = newSwitchableResponseWriter(rec)
+	sw.holdStreams = true
+	sw.Header().Set("Content-Type", "application/json")
+	sw.Write([]byte("{}"))
+	if sw.IsHolding() || rec.Body.String() != "{}" {
+		t.Errorf("JSON response should pass through, holding=%v", sw.IsHolding())
+	}
+}
+
+func TestStreamFailureStatus(t *testing.T) {
+	for reason, want := range map[string]int{
+		"overloaded_error": 529,
+		"rate_limit_error": 429,
+		streamTruncated:    502,
+		streamTimeout:      504,
+		"api_error":        500,
+	} {
+		if got := streamFailureStatus(reason); got != want {
+			t.Errorf("streamFailureStatus(%q) = %d, want %d", reason, got, want)
+		}
+	}
+}
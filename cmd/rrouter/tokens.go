@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode"
+)
+
+const (
+	// imageTokenEstimate is a flat per-image/document cost. Anthropic bills
+	// images by pixel area; without decoding we assume a typical screenshot.
+	imageTokenEstimate = 1600
+	// messageOverheadTokens approximates role markers and block framing.
+	messageOverheadTokens = 4
+)
+
+// ContextRoutingConfig reroutes or rejects requests based on the estimated
+// prompt size, before they are sent upstream.
+type ContextRoutingConfig struct {
+	Rules       []ContextRule `json:"rules,omitempty"`
+	RejectAbove int           `json:"rejectAbove,omitempty"` // 0 disables early rejection
+}
+
+// ContextRule rewrites the model when the estimated prompt exceeds Above tokens.
+// Match optionally restricts the rule to client model names (glob).
+type ContextRule struct {
+	Above   int    `json:"above"`
+	Match   string `json:"match,omitempty"`
+	Rewrite string `json:"rewrite"`
+}
+
+// estimateTextTokens is a local heuristic tokenizer. Runs of letters/digits
+// cost roughly one token per 4 characters, each punctuation mark or symbol
+// costs one token, and CJK/Hangul/Kana characters cost one token each.
+// Whitespace is folded into neighbouring tokens.
+func estimateTextTokens(s string) int {
+	tokens := 0
+	run := 0
+	flush := func() {
+		if run > 0 {
+			tokens += (run + 3) / 4
+			run = 0
+		}
+	}
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hangul, r) ||
+			unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			flush()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			run++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// estimateJSONTokens estimates the cost of an arbitrary JSON value (tool
+// inputs, schemas) by tokenizing its compact serialization.
+func estimateJSONTokens(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	if s, ok := v.(string); ok {
+		return estimateTextTokens(s)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return estimateTextTokens(string(b))
+}
+
+// estimateContentTokens estimates a message or system "content" value, which
+// is either a plain string or an array of content blocks.
+func estimateContentTokens(content interface{}) int {
+	switch v := content.(type) {
+	case string:
+		return estimateTextTokens(v)
+	case []interface{}:
+		total := 0
+		for _, item := range v {
+			block, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			total += estimateBlockTokens(block)
+		}
+		return total
+	default:
+		return 0
+	}
+}
+
+func estimateBlockTokens(block map[string]interface{}) int {
+	blockType, _ := block["type"].(string)
+	switch blockType {
+	case "text":
+		text, _ := block["text"].(string)
+		return estimateTextTokens(text)
+	case "thinking":
+		text, _ := block["thinking"].(string)
+		return estimateTextTokens(text)
+	case "image", "document":
+		return imageTokenEstimate
+	case "tool_use":
+		name, _ := block["name"].(string)
+		return estimateTextTokens(name) + estimateJSONTokens(block["input"])
+	case "tool_result":
+		return estimateContentTokens(block["content"])
+	default:
+		return 0
+	}
+}
+
+// estimateRequestTokens estimates the prompt size of a parsed Messages API
+// request from its system prompt, messages and tool definitions.
+func estimateRequestTokens(data map[string]interface{}) int {
+	total := estimateContentTokens(data["system"])
+
+	if messages, ok := data["messages"].([]interface{}); ok {
+		for _, msg := range messages {
+			msgMap, ok := msg.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			total += messageOverheadTokens + estimateContentTokens(msgMap["content"])
+		}
+	}
+
+	if tools, ok := data["tools"].([]interface{}); ok {
+		for _, tool := range tools {
+			toolMap, ok := tool.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := toolMap["name"].(string)
+			desc, _ := toolMap["description"].(string)
+			total += estimateTextTokens(name) + estimateTextTokens(desc) + estimateJSONTokens(toolMap["input_schema"])
+		}
+	}
+
+	return total
+}
+
+// applyContextRouting returns the model to use for a request of the given
+// estimated size. It picks the matching rule with the highest threshold that
+// the request exceeds, or returns a requestError when the request is above
+// RejectAbove.
+func applyContextRouting(cfg *ContextRoutingConfig, originalModel, currentModel string, tokens int) (string, error) {
+	if cfg == nil {
+		return currentModel, nil
+	}
+
+	if cfg.RejectAbove > 0 && tokens > cfg.RejectAbove {
+		return "", &requestError{
+			status:  400,
+			errType: "invalid_request_error",
+			message: fmt.Sprintf("prompt is too long: ~%d estimated tokens > %d maximum (rejected by rrouter)", tokens, cfg.RejectAbove),
+		}
+	}
+
+	var best *ContextRule
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if tokens <= rule.Above || rule.Rewrite == "" {
+			continue
+		}
+		if rule.Match != "" && !matchModel(rule.Match, originalModel) {
+			continue
+		}
+		if best == nil || rule.Above > best.Above {
+			best = rule
+		}
+	}
+	if best == nil {
+		return currentModel, nil
+	}
+	return best.Rewrite, nil
+}
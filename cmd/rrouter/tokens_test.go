@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEstimateTextTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected int
+	}{
+		{"empty", "", 0},
+		{"short word", "hi", 1},
+		{"four letters", "test", 1},
+		{"five letters", "tests", 2},
+		{"words and spaces", "hello world", 4},
+		{"punctuation counts", "a, b.", 4},
+		{"hangul per rune", "안녕", 2},
+		{"mixed", "fmt.Println(x)", 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateTextTokens(tt.input); got != tt.expected {
+				t.Errorf("estimateTextTokens(%q) = %d, want %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	body := `{
+		"system": [{"type": "text", "text": "abcd abcd"}],
+		"messages": [
+			{"role": "user", "content": "abcd"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "abcd"},
+				{"type": "tool_use", "name": "abcd", "input": {}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "content": [{"type": "text", "text": "abcd"}]},
+				{"type": "image", "source": {}}
+			]}
+		],
+		"tools": [{"name": "abcd", "description": "abcd", "input_schema": {}}]
+	}`
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		t.Fatalf("bad fixture: %v", err)
+	}
+
+	// system 2 + msgs (4+1) + (4+1+1+2) + (4+1+1600) + tools (1+1+2)
+	want := 2 + 5 + 8 + 1605 + 4
+	if got := estimateRequestTokens(data); got != want {
+		t.Errorf("estimateRequestTokens() = %d, want %d", got, want)
+	}
+}
+
+func TestApplyContextRouting(t *testing.T) {
+	cfg := &ContextRoutingConfig{
+		Rules: []ContextRule{
+			{Above: 100, Rewrite: "long-context"},
+			{Above: 500, Rewrite: "very-long-context"},
+			{Above: 50, Match: "claude-haiku-*", Rewrite: "haiku-long"},
+		},
+		RejectAbove: 1000,
+	}
+
+	tests := []struct {
+		name      string
+		cfg       *ContextRoutingConfig
+		original  string
+		tokens    int
+		expected  string
+		expectErr bool
+	}{
+		{"nil config keeps model", nil, "claude-sonnet-4-5", 5000, "current", false},
+		{"below all thresholds", cfg, "claude-sonnet-4-5", 10, "current", false},
+		{"exceeds first threshold", cfg, "claude-sonnet-4-5", 200, "long-context", false},
+		{"highest exceeded threshold wins", cfg, "claude-sonnet-4-5", 600, "very-long-context", false},
+		{"model-scoped rule applies", cfg, "claude-haiku-4-5", 60, "haiku-long", false},
+		{"model-scoped rule skipped for other models", cfg, "claude-sonnet-4-5", 60, "current", false},
+		{"threshold is exclusive", cfg, "claude-sonnet-4-5", 100, "current", false},
+		{"rejected above limit", cfg, "claude-sonnet-4-5", 1001, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyContextRouting(tt.cfg, tt.original, "current", tt.tokens)
+			if tt.expectErr {
+				var reqErr *requestError
+				if !errors.As(err, &reqErr) {
+					t.Fatalf("expected *requestError, got %v", err)
+				}
+				if reqErr.status != 400 || reqErr.errType != "invalid_request_error" {
+					t.Errorf("unexpected error fields: %+v", reqErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("applyContextRouting() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestModifyRequestBody_ContextRouting(t *testing.T) {
+	mc := &ModeConfig{
+		Mappings: []ModelMapping{{Match: "claude-sonnet-*", Rewrite: "gemini-sonnet"}},
+		ContextRouting: &ContextRoutingConfig{
+			Rules:       []ContextRule{{Above: 10, Rewrite: "gemini-long"}},
+			RejectAbove: 100,
+		},
+	}
+
+	small := `{"model": "claude-sonnet-4-5", "messages": [{"role": "user", "content": "hi"}]}`
+	out, err := modifyRequestBody([]byte(small), mc, "antigravity")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(out), `"gemini-sonnet"`) {
+		t.Errorf("small request should use standard mapping, got %s", out)
+	}
+
+	medium := `{"model": "claude-sonnet-4-5", "messages": [{"role": "user", "content": "` + strings.Repeat("word ", 30) + `"}]}`
+	out, err = modifyRequestBody([]byte(medium), mc, "antigravity")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(out), `"gemini-long"`) {
+		t.Errorf("medium request should be rerouted, got %s", out)
+	}
+
+	large := `{"model": "claude-sonnet-4-5", "messages": [{"role": "user", "content": "` + strings.Repeat("word ", 200) + `"}]}`
+	if _, err := modifyRequestBody([]byte(large), mc, "antigravity"); err == nil {
+		t.Error("large request should be rejected")
+	}
+}
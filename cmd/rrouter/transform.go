@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParamTransform rewrites top-level or nested request fields (dotted paths
+// such as "thinking.budget_tokens") for backends with different parameter
+// support. Operations run in order: delete, rename, set, clamp.
+type ParamTransform struct {
+	Set    map[string]interface{} `json:"set,omitempty"`
+	Clamp  map[string]ClampRange  `json:"clamp,omitempty"`
+	Rename map[string]string      `json:"rename,omitempty"`
+	Delete []string               `json:"delete,omitempty"`
+}
+
+// ClampRange bounds a numeric field. Either side may be omitted.
+type ClampRange struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// lookupPath returns the parent map and final key for a dotted path.
+// When create is true, missing intermediate objects are created.
+func lookupPath(data map[string]interface{}, path string, create bool) (map[string]interface{}, string) {
+	parts := strings.Split(path, ".")
+	cur := data
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			if !create {
+				return nil, ""
+			}
+			next = make(map[string]interface{})
+			cur[p] = next
+		}
+		cur = next
+	}
+	return cur, parts[len(parts)-1]
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applyParamTransform applies t to the request data in place and returns a
+// human-readable description of each change made (for logging).
+func applyParamTransform(data map[string]interface{}, t *ParamTransform) []string {
+	if t == nil {
+		return nil
+	}
+	var changes []string
+
+	for _, path := range t.Delete {
+		parent, key := lookupPath(data, path, false)
+		if parent == nil {
+			continue
+		}
+		if _, ok := parent[key]; ok {
+			delete(parent, key)
+			changes = append(changes, "delete "+path)
+		}
+	}
+
+	for _, from := range sortedKeys(t.Rename) {
+		to := t.Rename[from]
+		parent, key := lookupPath(data, from, false)
+		if parent == nil {
+			continue
+		}
+		val, ok := parent[key]
+		if !ok {
+			continue
+		}
+		delete(parent, key)
+		dst, dstKey := lookupPath(data, to, true)
+		dst[dstKey] = val
+		changes = append(changes, fmt.Sprintf("rename %s -> %s", from, to))
+	}
+
+	for _, path := range sortedKeys(t.Set) {
+		parent, key := lookupPath(data, path, true)
+		parent[key] = t.Set[path]
+		changes = append(changes, fmt.Sprintf("set %s=%v", path, t.Set[path]))
+	}
+
+	for _, path := range sortedKeys(t.Clamp) {
+		r := t.Clamp[path]
+		parent, key := lookupPath(data, path, false)
+		if parent == nil {
+			continue
+		}
+		num, ok := parent[key].(float64)
+		if !ok {
+			continue
+		}
+		clamped := num
+		if r.Min != nil && clamped < *r.Min {
+			clamped = *r.Min
+		}
+		if r.Max != nil && clamped > *r.Max {
+			clamped = *r.Max
+		}
+		if clamped != num {
+			parent[key] = clamped
+			changes = append(changes, fmt.Sprintf("clamp %s %v -> %v", path, num, clamped))
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestApplyParamTransform(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		transform *ParamTransform
+		expected  string
+		changes   int
+	}{
+		{
+			name:      "nil transform is a no-op",
+			body:      `{"max_tokens": 100}`,
+			transform: nil,
+			expected:  `{"max_tokens": 100}`,
+		},
+		{
+			name:      "delete top-level and nested fields",
+			body:      `{"top_k": 5, "metadata": {"user_id": "u", "keep": 1}}`,
+			transform: &ParamTransform{Delete: []string{"top_k", "metadata.user_id", "missing.path"}},
+			expected:  `{"metadata": {"keep": 1}}`,
+			changes:   2,
+		},
+		{
+			name:      "rename field",
+			body:      `{"stop": ["x"]}`,
+			transform: &ParamTransform{Rename: map[string]string{"stop": "stop_sequences"}},
+			expected:  `{"stop_sequences": ["x"]}`,
+			changes:   1,
+		},
+		{
+			name:      "set creates nested objects",
+			body:      `{}`,
+			transform: &ParamTransform{Set: map[string]interface{}{"thinking.type": "enabled", "temperature": 1.0}},
+			expected:  `{"thinking": {"type": "enabled"}, "temperature": 1}`,
+			changes:   2,
+		},
+		{
+			name: "clamp numeric fields",
+			body: `{"max_tokens": 64000, "thinking": {"budget_tokens": 100}, "temperature": 0.5}`,
+			transform: &ParamTransform{Clamp: map[string]ClampRange{
+				"max_tokens":             {Max: floatPtr(32000)},
+				"thinking.budget_tokens": {Min: floatPtr(1024), Max: floatPtr(24576)},
+				"temperature":            {Min: floatPtr(0), Max: floatPtr(1)},
+			}},
+			expected: `{"max_tokens": 32000, "thinking": {"budget_tokens": 1024}, "temperature": 0.5}`,
+			changes:  2,
+		},
+		{
+			name:      "clamp ignores non-numeric and missing fields",
+			body:      `{"max_tokens": "lots"}`,
+			transform: &ParamTransform{Clamp: map[string]ClampRange{"max_tokens": {Max: floatPtr(10)}, "top_p": {Max: floatPtr(1)}}},
+			expected:  `{"max_tokens": "lots"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data, want map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.body), &data); err != nil {
+				t.Fatalf("bad body fixture: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.expected), &want); err != nil {
+				t.Fatalf("bad expected fixture: %v", err)
+			}
+
+			changes := applyParamTransform(data, tt.transform)
+			if !reflect.DeepEqual(data, want) {
+				t.Errorf("applyParamTransform() = %v, want %v", data, want)
+			}
+			if len(changes) != tt.changes {
+				t.Errorf("got %d changes %v, want %d", len(changes), changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestModifyRequestBody_Transform(t *testing.T) {
+	mc := &ModeConfig{
+		Mappings: []ModelMapping{
+			{
+				Match:     "claude-sonnet-*",
+				Rewrite:   "gemini-sonnet",
+				Transform: &ParamTransform{Clamp: map[string]ClampRange{"max_tokens": {Max: floatPtr(8192)}}},
+			},
+			{Match: "claude-haiku-*", Rewrite: "gemini-flash"},
+		},
+		Transform: &ParamTransform{Delete: []string{"top_k"}},
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		expected map[string]interface{}
+	}{
+		{
+			name:     "mode and mapping transforms both apply",
+			body:     `{"model": "claude-sonnet-4-5", "max_tokens": 64000, "top_k": 5}`,
+			expected: map[string]interface{}{"model": "gemini-sonnet", "max_tokens": 8192.0},
+		},
+		{
+			name:     "only mode transform when mapping has none",
+			body:     `{"model": "claude-haiku-4-5", "max_tokens": 64000, "top_k": 5}`,
+			expected: map[string]interface{}{"model": "gemini-flash", "max_tokens": 64000.0},
+		},
+		{
+			name:     "no rewrite means no transform",
+			body:     `{"model": "gpt-4", "max_tokens": 64000, "top_k": 5}`,
+			expected: map[string]interface{}{"model": "gpt-4", "max_tokens": 64000.0, "top_k": 5.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := modifyRequestBody([]byte(tt.body), mc, "antigravity")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("bad output JSON: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("modifyRequestBody() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
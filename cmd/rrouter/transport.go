@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upstreamTimeoutError is returned when a first-byte or idle-stream timeout
+// fires. It is a net.Error with Timeout() true, so it is handled like any
+// other upstream timeout.
+type upstreamTimeoutError struct {
+	what string
+	d    time.Duration
+}
+
+func (e *upstreamTimeoutError) Error() string {
+	return fmt.Sprintf("upstream %s timeout (%s)", e.what, e.d)
+}
+func (e *upstreamTimeoutError) Timeout() bool   { return true }
+func (e *upstreamTimeoutError) Temporary() bool { return true }
+
+var _ net.Error = (*upstreamTimeoutError)(nil)
+
+// parseTimeout parses an optional duration setting.
+func parseTimeout(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return d, nil
+}
+
+// newUpstreamTransport returns a transport for an upstream, or nil to use
+// http.DefaultTransport when nothing is tuned.
+func newUpstreamTransport(cfg *UpstreamConfig) (http.RoundTripper, error) {
+	var dial, tlsHandshake, responseHeader, firstByte, idleStream time.Duration
+	for _, s := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"dialTimeout", cfg.DialTimeout, &dial},
+		{"tlsHandshakeTimeout", cfg.TLSHandshakeTimeout, &tlsHandshake},
+		{"responseHeaderTimeout", cfg.ResponseHeaderTimeout, &responseHeader},
+		{"firstByteTimeout", cfg.FirstByteTimeout, &firstByte},
+		{"idleStreamTimeout", cfg.IdleStreamTimeout, &idleStream},
+	} {
+		d, err := parseTimeout(s.name, s.value)
+		if err != nil {
+			return nil, err
+		}
+		*s.dst = d
+	}
+
+	if dial == 0 && tlsHandshake == 0 && responseHeader == 0 && firstByte == 0 && idleStream == 0 &&
+		cfg.MaxIdleConns == 0 && cfg.MaxIdleConnsPerHost == 0 && cfg.HTTP2 == nil {
+		return nil, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if dial > 0 {
+		t.DialContext = (&net.Dialer{Timeout: dial, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if tlsHandshake > 0 {
+		t.TLSHandshakeTimeout = tlsHandshake
+	}
+	t.ResponseHeaderTimeout = responseHeader
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.HTTP2 != nil {
+		t.ForceAttemptHTTP2 = *cfg.HTTP2
+		if !*cfg.HTTP2 {
+			// A non-nil empty map disables HTTP/2 negotiation
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}
+
+	if firstByte == 0 && idleStream == 0 {
+		return t, nil
+	}
+	return &bodyTimeoutTransport{base: t, firstByte: firstByte, idle: idleStream}, nil
+}
+
+// bodyTimeoutTransport enforces time-to-first-byte and idle-stream limits on
+// response bodies by cancelling the request when a read takes too long.
+type bodyTimeoutTransport struct {
+	base      http.RoundTripper
+	firstByte time.Duration
+	idle      time.Duration
+}
+
+func (t *bodyTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = newWatchdogBody(resp.Body, cancel, t.firstByte, t.idle)
+	return resp, nil
+}
+
+// watchdogBody cancels its request if the first byte (or, after that, the
+// next byte) doesn't arrive in time, and reports the resulting read error as
+// an upstreamTimeoutError.
+type watchdogBody struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+	idle   time.Duration
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	started bool
+	fired   *upstreamTimeoutError
+}
+
+func newWatchdogBody(body io.ReadCloser, cancel context.CancelFunc, firstByte, idle time.Duration) *watchdogBody {
+	w := &watchdogBody{body: body, cancel: cancel, idle: idle}
+	switch {
+	case firstByte > 0:
+		w.arm("first-byte", firstByte)
+	case idle > 0:
+		w.arm("idle-stream", idle)
+	}
+	return w
+}
+
+// arm (re)starts the timer. Must not be called with w.mu held.
+func (w *watchdogBody) arm(what string, d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(d, func() {
+		w.mu.Lock()
+		w.fired = &upstreamTimeoutError{what: what, d: d}
+		w.mu.Unlock()
+		w.cancel()
+	})
+}
+
+func (w *watchdogBody) Read(p []byte) (int, error) {
+	n, err := w.body.Read(p)
+
+	w.mu.Lock()
+	fired := w.fired
+	w.mu.Unlock()
+	if fired != nil {
+		return n, fired
+	}
+
+	if n > 0 {
+		if w.idle > 0 {
+			w.arm("idle-stream", w.idle)
+		} else if !w.started {
+			w.stop()
+		}
+		w.started = true
+	}
+	if err != nil {
+		w.stop()
+	}
+	return n, err
+}
+
+func (w *watchdogBody) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (w *watchdogBody) Close() error {
+	w.stop()
+	w.cancel()
+	return w.body.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewUpstreamTransport(t *testing.T) {
+	disabled := false
+
+	tests := []struct {
+		name    string
+		cfg     *UpstreamConfig
+		wantNil bool
+		wantErr bool
+		check   func(t *testing.T, rt http.RoundTripper)
+	}{
+		{name: "untuned uses default transport", cfg: &UpstreamConfig{URL: "http://a"}, wantNil: true},
+		{name: "invalid duration", cfg: &UpstreamConfig{DialTimeout: "fast"}, wantErr: true},
+		{name: "negative duration", cfg: &UpstreamConfig{IdleStreamTimeout: "-1s"}, wantErr: true},
+		{
+			name: "transport settings",
+			cfg: &UpstreamConfig{
+				TLSHandshakeTimeout:   "5s",
+				ResponseHeaderTimeout: "30s",
+				MaxIdleConns:          50,
+				MaxIdleConnsPerHost:   20,
+				HTTP2:                 &disabled,
+			},
+			check: func(t *testing.T, rt http.RoundTripper) {
+				tr, ok := rt.(*http.Transport)
+				if !ok {
+					t.Fatalf("got %T, want *http.Transport", rt)
+				}
+				if tr.TLSHandshakeTimeout != 5*time.Second || tr.ResponseHeaderTimeout != 30*time.Second {
+					t.Errorf("timeouts = %s, %s", tr.TLSHandshakeTimeout, tr.ResponseHeaderTimeout)
+				}
+				if tr.MaxIdleConns != 50 || tr.MaxIdleConnsPerHost != 20 {
+					t.Errorf("idle conns = %d, %d", tr.MaxIdleConns, tr.MaxIdleConnsPerHost)
+				}
+				if tr.ForceAttemptHTTP2 || tr.TLSNextProto == nil {
+					t.Error("HTTP/2 should be disabled")
+				}
+			},
+		},
+		{
+			name: "body timeouts wrap the transport",
+			cfg:  &UpstreamConfig{FirstByteTimeout: "10s", IdleStreamTimeout: "30s"},
+			check: func(t *testing.T, rt http.RoundTripper) {
+				bt, ok := rt.(*bodyTimeoutTransport)
+				if !ok {
+					t.Fatalf("got %T, want *bodyTimeoutTransport", rt)
+				}
+				if bt.firstByte != 10*time.Second || bt.idle != 30*time.Second {
+					t.Errorf("body timeouts = %s, %s", bt.firstByte, bt.idle)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, err := newUpstreamTransport(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (rt == nil) != tt.wantNil {
+				t.Fatalf("transport = %v, wantNil %v", rt, tt.wantNil)
+			}
+			if tt.check != nil {
+				tt.check(t, rt)
+			}
+		})
+	}
+}
+
+// newStallingServer sends headers and an optional first chunk, then stalls
+// until the client goes away.
+func newStallingServer(t *testing.T, first string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		if first != "" {
+			io.WriteString(w, first)
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestBodyTimeoutTransport(t *testing.T) {
+	tests := []struct {
+		name  string
+		first string
+		cfg   *UpstreamConfig
+		want  string
+	}{
+		{"first byte", "", &UpstreamConfig{FirstByteTimeout: "50ms"}, "first-byte"},
+		{"idle stream", "data: {}\n\n", &UpstreamConfig{IdleStreamTimeout: "50ms"}, "idle-stream"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newStallingServer(t, tt.first)
+			rt, err := newUpstreamTransport(tt.cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req, _ := http.NewRequest("GET", srv.URL, nil)
+			resp, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+
+			start := time.Now()
+			body, err := io.ReadAll(resp.Body)
+			var timeoutErr *upstreamTimeoutError
+			if !errors.As(err, &timeoutErr) || timeoutErr.what != tt.want {
+				t.Fatalf("err = %v, want %s timeout", err, tt.want)
+			}
+			var netErr net.Error
+			if !errors.As(err, &netErr) || !netErr.Timeout() {
+				t.Error("timeout must be reported as a net.Error timeout")
+			}
+			if string(body) != tt.first {
+				t.Errorf("body = %q, want %q", body, tt.first)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("timeout took %s", elapsed)
+			}
+		})
+	}
+}
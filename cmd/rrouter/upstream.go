@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// defaultUpstreamName identifies the RROUTER_UPSTREAM endpoint, used by
+// modes that don't declare their own upstream.
+const defaultUpstreamName = "default"
+
+// UpstreamConfig describes one backend endpoint rrouter can forward to.
+// An entry named "default" tunes the RROUTER_UPSTREAM endpoint; its URL,
+// if set, replaces RROUTER_UPSTREAM.
+type UpstreamConfig struct {
+	URL string `json:"url"`
+	// Headers are set on every request forwarded to this upstream
+	// (e.g. an API key or a routing header for a second proxy).
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Timeouts are Go durations (e.g. "60s"); empty means no limit.
+	DialTimeout           string `json:"dialTimeout,omitempty"`
+	TLSHandshakeTimeout   string `json:"tlsHandshakeTimeout,omitempty"`
+	ResponseHeaderTimeout string `json:"responseHeaderTimeout,omitempty"`
+	FirstByteTimeout      string `json:"firstByteTimeout,omitempty"`  // headers to first body byte
+	IdleStreamTimeout     string `json:"idleStreamTimeout,omitempty"` // max gap between body reads (SSE)
+
+	MaxIdleConns        int   `json:"maxIdleConns,omitempty"`
+	MaxIdleConnsPerHost int   `json:"maxIdleConnsPerHost,omitempty"`
+	HTTP2               *bool `json:"http2,omitempty"` // default: Go's default (HTTP/2 over TLS)
+}
+
+// upstream is a resolved backend endpoint with its own reverse proxy.
+type upstream struct {
+	name  string
+	url   string
+	proxy *httputil.ReverseProxy
+}
+
+// upstreamSet maps modes to upstream pools. Built once at startup.
+type upstreamSet struct {
+	byName map[string]*upstream
+	pools  map[string]*upstreamPool // configured pools plus one per plain upstream
+	byMode map[string]*upstreamPool
+	def    *upstreamPool
+}
+
+// newUpstreamSet builds proxies for every named upstream in cfg plus the
+// default (RROUTER_UPSTREAM), builds the configured pools, and resolves each
+// mode's "upstream" setting, which may be a pool name, an upstream name or a
+// literal URL.
+func newUpstreamSet(cfg *Config, defaultURL string) (*upstreamSet, error) {
+	set := &upstreamSet{
+		byName: make(map[string]*upstream),
+		pools:  make(map[string]*upstreamPool),
+		byMode: make(map[string]*upstreamPool),
+	}
+
+	add := func(name string, uc *UpstreamConfig) (*upstream, error) {
+		u, err := url.Parse(uc.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("upstream %q: invalid url %q", name, uc.URL)
+		}
+		proxy, err := createReverseProxy(uc)
+		if err != nil {
+			return nil, fmt.Errorf("upstream %q: %w", name, err)
+		}
+		up := &upstream{name: name, url: uc.URL, proxy: proxy}
+		set.byName[name] = up
+		single := newUpstreamPool(name, "")
+		single.addMember(up, 1)
+		set.pools[name] = single
+		return up, nil
+	}
+	// lookup resolves an upstream name, adding a literal URL on first use
+	lookup := func(ref string) (*upstream, error) {
+		if up, ok := set.byName[ref]; ok {
+			return up, nil
+		}
+		return add(ref, &UpstreamConfig{URL: ref})
+	}
+
+	def := &UpstreamConfig{URL: defaultURL}
+	if uc, ok := cfg.Upstreams[defaultUpstreamName]; ok {
+		tuned := *uc
+		if tuned.URL == "" {
+			tuned.URL = defaultURL
+		}
+		def = &tuned
+	}
+	if _, err := add(defaultUpstreamName, def); err != nil {
+		return nil, err
+	}
+	set.def = set.pools[defaultUpstreamName]
+
+	for _, name := range sortedKeys(cfg.Upstreams) {
+		if name == defaultUpstreamName {
+			continue
+		}
+		if _, err := add(name, cfg.Upstreams[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range sortedKeys(cfg.Pools) {
+		pc := cfg.Pools[name]
+		if _, ok := set.pools[name]; ok {
+			return nil, fmt.Errorf("pool %q: name already used by an upstream", name)
+		}
+		if !validPoolStrategy(pc.Strategy) {
+			return nil, fmt.Errorf("pool %q: unknown strategy %q", name, pc.Strategy)
+		}
+		if len(pc.Members) == 0 {
+			return nil, fmt.Errorf("pool %q: no members", name)
+		}
+		pool := newUpstreamPool(name, pc.Strategy)
+		for _, member := range pc.Members {
+			up, err := lookup(member.Upstream)
+			if err != nil {
+				return nil, fmt.Errorf("pool %q: %w", name, err)
+			}
+			pool.addMember(up, member.Weight)
+		}
+		set.pools[name] = pool
+	}
+
+	for _, mode := range sortedKeys(cfg.Modes) {
+		ref := cfg.Modes[mode].Upstream
+		if ref == "" {
+			continue
+		}
+		pool, ok := set.pools[ref]
+		if !ok {
+			// Not a named pool or upstream: treat as a literal URL
+			if _, err := add(ref, &UpstreamConfig{URL: ref}); err != nil {
+				return nil, fmt.Errorf("mode %q: upstream %q is neither a configured upstream nor a valid URL", mode, ref)
+			}
+			pool = set.pools[ref]
+		}
+		set.byMode[mode] = pool
+	}
+
+	return set, nil
+}
+
+// forMode returns the upstream pool for a resolved target mode.
+func (s *upstreamSet) forMode(mode string) *upstreamPool {
+	if pool, ok := s.byMode[mode]; ok {
+		return pool
+	}
+	return s.def
+}
+
+// HealthInfo returns upstream URLs, mode assignments and pool health for
+// /health.
+func (s *upstreamSet) HealthInfo() map[string]interface{} {
+	urls := make(map[string]string, len(s.byName))
+	for name, up := range s.byName {
+		urls[name] = up.url
+	}
+	modes := make(map[string]string, len(s.byMode))
+	for mode, pool := range s.byMode {
+		modes[mode] = pool.name
+	}
+	info := map[string]interface{}{
+		"upstreams":     urls,
+		"modeUpstreams": modes,
+	}
+	pools := make(map[string]interface{})
+	for name, pool := range s.pools {
+		if _, plain := s.byName[name]; !plain {
+			pools[name] = pool.HealthInfo()
+		}
+	}
+	if len(pools) > 0 {
+		info["pools"] = pools
+	}
+	return info
+}
+
+// logUpstreams prints the upstream table at startup.
+func (s *upstreamSet) logUpstreams() {
+	for _, name := range sortedKeys(s.byName) {
+		log.Printf("  Upstream %-10s %s", name+":", s.byName[name].url)
+	}
+	for _, name := range sortedKeys(s.pools) {
+		if _, plain := s.byName[name]; plain {
+			continue
+		}
+		pool := s.pools[name]
+		members := make([]string, 0, len(pool.members))
+		for _, m := range pool.members {
+			members = append(members, fmt.Sprintf("%s(%d)", m.up.name, m.weight))
+		}
+		log.Printf("  Pool %s (%s): %s", name, pool.strategy, strings.Join(members, ", "))
+	}
+	for _, mode := range sortedKeys(s.byMode) {
+		log.Printf("  Mode %s -> upstream %s", mode, s.byMode[mode].name)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewUpstreamSet_ResolvesModes(t *testing.T) {
+	cfg := &Config{
+		Upstreams: map[string]*UpstreamConfig{
+			"cliproxy": {URL: "http://localhost:8317"},
+		},
+		Modes: map[string]ModeConfig{
+			"antigravity": {Upstream: "cliproxy"},
+			"claude":      {Upstream: "https://api.anthropic.com"},
+			"other":       {},
+		},
+	}
+	set, err := newUpstreamSet(cfg, "http://localhost:8316")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		mode string
+		name string
+		url  string
+	}{
+		{"antigravity", "cliproxy", "http://localhost:8317"},
+		{"claude", "https://api.anthropic.com", "https://api.anthropic.com"},
+		{"other", defaultUpstreamName, "http://localhost:8316"},
+		{"unknown", defaultUpstreamName, "http://localhost:8316"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			pool := set.forMode(tt.mode)
+			if pool.name != tt.name || pool.members[0].up.url != tt.url {
+				t.Errorf("forMode(%q) = %s (%s), want %s (%s)", tt.mode, pool.name, pool.members[0].up.url, tt.name, tt.url)
+			}
+		})
+	}
+}
+
+func TestNewUpstreamSet_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+	}{
+		{"invalid mode upstream", &Config{Modes: map[string]ModeConfig{"claude": {Upstream: "not-a-url"}}}},
+		{"invalid upstream url", &Config{Upstreams: map[string]*UpstreamConfig{"x": {URL: "://bad"}}}},
+		{"invalid timeout", &Config{Upstreams: map[string]*UpstreamConfig{"x": {URL: "http://a", ResponseHeaderTimeout: "soon"}}}},
+		{"invalid default tuning", &Config{Upstreams: map[string]*UpstreamConfig{defaultUpstreamName: {DialTimeout: "-"}}}},
+		{"empty pool", &Config{Pools: map[string]*PoolConfig{"p": {}}}},
+		{"unknown strategy", &Config{Pools: map[string]*PoolConfig{"p": {Strategy: "fastest", Members: []PoolMember{{Upstream: "http://a"}}}}}},
+		{"pool name clash", &Config{
+			Upstreams: map[string]*UpstreamConfig{"p": {URL: "http://a"}},
+			Pools:     map[string]*PoolConfig{"p": {Members: []PoolMember{{Upstream: "p"}}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newUpstreamSet(tt.cfg, "http://localhost:8316"); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestCreateReverseProxy_SetsHeaders(t *testing.T) {
+	var gotKey, gotHost string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("X-Api-Key")
+		gotHost = r.Host
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	proxy, err := createReverseProxy(&UpstreamConfig{
+		URL:     backend.URL,
+		Headers: map[string]string{"X-Api-Key": "secret"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/messages", nil)
+	req.Header.Set("X-Api-Key", "client-key")
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if gotKey != "secret" {
+		t.Errorf("X-Api-Key = %q, want upstream override", gotKey)
+	}
+	if gotHost != backend.Listener.Addr().String() {
+		t.Errorf("Host = %q, want %q", gotHost, backend.Listener.Addr().String())
+	}
+}
+
+func TestNewUpstreamSet_Pools(t *testing.T) {
+	cfg := &Config{
+		Upstreams: map[string]*UpstreamConfig{
+			"acct1": {URL: "http://localhost:8317"},
+		},
+		Pools: map[string]*PoolConfig{
+			"cliproxy": {
+				Strategy: poolStrategyLeastInflight,
+				Members: []PoolMember{
+					{Upstream: "acct1", Weight: 2},
+					{Upstream: "http://localhost:8318"},
+				},
+			},
+		},
+		Modes: map[string]ModeConfig{"antigravity": {Upstream: "cliproxy"}},
+	}
+	set, err := newUpstreamSet(cfg, "http://localhost:8316")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pool := set.forMode("antigravity")
+	if pool.name != "cliproxy" || pool.strategy != poolStrategyLeastInflight || len(pool.members) != 2 {
+		t.Fatalf("pool = %s/%s with %d members", pool.name, pool.strategy, len(pool.members))
+	}
+	if pool.members[0].weight != 2 || pool.members[1].weight != 1 {
+		t.Errorf("weights = %d, %d; want 2, 1", pool.members[0].weight, pool.members[1].weight)
+	}
+	if pool.members[0].up != set.byName["acct1"] {
+		t.Error("pool member should share the named upstream's proxy")
+	}
+
+	pools, _ := set.HealthInfo()["pools"].(map[string]interface{})
+	if _, ok := pools["cliproxy"]; !ok || len(pools) != 1 {
+		t.Errorf("health pools = %v, want only cliproxy", pools)
+	}
+}
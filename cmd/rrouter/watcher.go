@@ -19,13 +19,24 @@ type ConfigWatcher struct {
 	config  *Config
 	watcher *fsnotify.Watcher
 	dir     string // ~/.rrouter/
+
+	// Set for profile watchers (see profile.go); zero values mean
+	// appConfig.DefaultMode, the global autoSwitch and the default
+	// profile's session pins.
+	profile     string
+	defaultMode string
+	auto        *autoState
 }
 
 func newConfigWatcher(dir string, defaultConfig *Config) *ConfigWatcher {
-	cw := &ConfigWatcher{
+	return startConfigWatcher(&ConfigWatcher{
 		dir:    dir,
 		config: defaultConfig,
-	}
+	})
+}
+
+func startConfigWatcher(cw *ConfigWatcher) *ConfigWatcher {
+	dir := cw.dir
 
 	// Initial read
 	cw.mode = cw.readModeFile()
@@ -72,9 +83,12 @@ func (cw *ConfigWatcher) watchLoop() {
 					cw.mode = newMode
 
 					// Clear auto state on explicit mode switch
-					if oldMode == "auto" && newMode != "auto" && autoSwitch != nil {
+					if auto := cw.autoState(); oldMode == "auto" && newMode != "auto" && auto != nil {
 						log.Printf("[AUTO] Mode changed from 'auto' to '%s' -- clearing auto-switch state", newMode)
-						autoSwitch.reset()
+						auto.reset()
+						if sessionPins != nil {
+							sessionPins.clear(cw.profile)
+						}
 					}
 
 					cw.mu.Unlock()
@@ -89,6 +103,7 @@ func (cw *ConfigWatcher) watchLoop() {
 						cw.config = cfg
 						cw.mu.Unlock()
 						log.Printf("[WATCHER] Config reloaded")
+						reloadSchedule(cfg.Schedule)
 					}
 				}
 			}
@@ -101,6 +116,21 @@ func (cw *ConfigWatcher) watchLoop() {
 	}
 }
 
+// autoState returns the auto-switch state this watcher's mode drives.
+func (cw *ConfigWatcher) autoState() *autoState {
+	if cw.auto != nil {
+		return cw.auto
+	}
+	return autoSwitch
+}
+
+func (cw *ConfigWatcher) defaultModeName() string {
+	if cw.defaultMode != "" {
+		return cw.defaultMode
+	}
+	return appConfig.DefaultMode
+}
+
 func (cw *ConfigWatcher) readModeFile() string {
 	path := filepath.Join(cw.dir, "mode")
 	content, err := os.ReadFile(path)
@@ -108,7 +138,7 @@ func (cw *ConfigWatcher) readModeFile() string {
 		if !os.IsNotExist(err) {
 			log.Printf("[WATCHER] Error reading mode file: %v", err)
 		}
-		return appConfig.DefaultMode
+		return cw.defaultModeName()
 	}
 	mode := strings.TrimSpace(string(content))
 	// "auto" is valid for auto-routing, plus any mode in config
@@ -116,8 +146,8 @@ func (cw *ConfigWatcher) readModeFile() string {
 		return mode
 	}
 	if _, ok := appConfig.Modes[mode]; !ok {
-		log.Printf("[WATCHER] Unknown mode '%s', defaulting to %s", mode, appConfig.DefaultMode)
-		return appConfig.DefaultMode
+		log.Printf("[WATCHER] Unknown mode '%s', defaulting to %s", mode, cw.defaultModeName())
+		return cw.defaultModeName()
 	}
 	return mode
 }